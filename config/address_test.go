@@ -0,0 +1,38 @@
+package config
+
+import "testing"
+
+func TestCheckServerAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		valid   bool
+	}{
+		{"8.8.8.8", true},
+		{"8.8.8.8:5353", true},
+		{"2606:4700:4700::1111", true},
+		{"[2606:4700:4700::1111]", true},
+		{"[2606:4700:4700::1111]:53", true},
+		{"dns.google", true},
+		{"dns.google:53", true},
+		{"localhost", true},
+		{"", false},
+		{"8.8.8.8:0", false},
+		{"8.8.8.8:65536", false},
+		{"dns.google:dns", false},
+		{"[dns.google]", false},
+		{"[2606:4700:4700::1111", false},
+		{"2606:4700:4700::1111:53:", false},
+		{"dns..google", false},
+		{"-dns.google", false},
+		{"dns_google.com/path", false},
+	}
+	for _, tt := range tests {
+		err := checkServerAddress(tt.address)
+		if tt.valid && err != nil {
+			t.Errorf("checkServerAddress(%q) = %v, want nil", tt.address, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("checkServerAddress(%q) = nil, want an error", tt.address)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// ClassLabel is added to every metric of targets of a class other than IN,
+// holding the class. Metrics of IN targets get it with an empty value, which
+// leaves their series as they were.
+const ClassLabel = "class"
+
+// Query classes targets can be queried in, by RFC 1035 code
+const (
+	ClassIN = "IN"
+	ClassCH = "CH"
+	ClassHS = "HS"
+)
+
+var classCodes = map[string]uint16{ClassIN: 1, ClassCH: 3, ClassHS: 4}
+
+// classAliases maps the long names of classes to their abbreviations
+var classAliases = map[string]string{"INTERNET": ClassIN, "CHAOS": ClassCH, "HESIOD": ClassHS}
+
+// addressRecordTypes are the record types that are rarely answered in the
+// CHAOS class
+var addressRecordTypes = []string{"A", "AAAA", "HTTPS", "SVCB"}
+
+// normalizeClass returns the abbreviation of class in upper case, IN when
+// it is empty
+func normalizeClass(class string) string {
+	class = strings.ToUpper(strings.TrimSpace(class))
+	if class == "" {
+		return ClassIN
+	}
+	if alias, ok := classAliases[class]; ok {
+		return alias
+	}
+	return class
+}
+
+// QueryClass returns the RFC 1035 code of the class the target is queried in
+func (t Target) QueryClass() uint16 {
+	return classCodes[normalizeClass(t.Class)]
+}
+
+// validateClass checks the class of the target at field
+func (t Target) validateClass(field string) []error {
+	var errs []error
+	if _, ok := classCodes[t.Class]; !ok {
+		errs = append(errs, fmt.Errorf("%s.class: unknown class %q, supported are IN, CH and HS", field, t.Class))
+	}
+	if t.Class != ClassIN && slices.Contains(t.RecordTypes, "DS") {
+		errs = append(errs, fmt.Errorf("%s.class: DS records can only be queried in class IN", field))
+	}
+	return errs
+}
+
+// Warnings returns problems of the configuration that do not prevent the
+// exporter from running but are likely mistakes
+func (c *Config) Warnings() []string {
+	var warnings []string
+	for i, target := range c.Targets {
+		if target.Class != ClassCH {
+			continue
+		}
+		for _, recordType := range target.RecordTypes {
+			if slices.Contains(addressRecordTypes, recordType) {
+				warnings = append(warnings, fmt.Sprintf("%s: %s records are queried in class CH, which servers rarely answer for other types than TXT", target.field(i), recordType))
+			}
+		}
+	}
+	return warnings
+}
@@ -1,71 +1,961 @@
 package config
 
 import (
+	"cmp"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io/fs"
+	"maps"
+	"net"
+	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
-
-	"gopkg.in/yaml.v2"
 )
 
 // Config represents the application configuration
 type Config struct {
-	Server     ServerConfig  `yaml:"server"`
-	Monitoring MonitorConfig `yaml:"monitoring"`
-	DNSServers []DNSServer   `yaml:"dns_servers"`
-	Targets    []Target      `yaml:"targets"`
+	Server        ServerConfig   `yaml:"server"`
+	Monitoring    MonitorConfig  `yaml:"monitoring"`
+	DNSServers    []DNSServer    `yaml:"dns_servers"`
+	Targets       []Target       `yaml:"targets"`
+	ZoneTransfers []ZoneTransfer `yaml:"zone_transfers"`
+
+	// Settings of targets that do not specify them
+	Defaults TargetDefaults `yaml:"defaults"`
+
+	// Monitoring profiles with targets and servers of their own, added to
+	// Targets and DNSServers by LoadConfig
+	Jobs []Job `yaml:"jobs"`
+
+	// Also monitor the nameservers listed in /etc/resolv.conf
+	DNSServersFromResolvConf bool `yaml:"dns_servers_from_resolvconf"`
+
+	// SHA-256 of the configuration file, set by LoadConfig
+	Hash string `yaml:"-"`
 }
 
 // ServerConfig contains HTTP server configuration
 type ServerConfig struct {
-	Port int `yaml:"port"`
+	// Interface and port the metrics are served on, the host defaults to
+	// all interfaces
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+	// Both in the host:port form instead, e.g. "127.0.0.1:9653" or
+	// "[::1]:9653"
+	ListenAddress string `yaml:"listen_address"`
+	// Unix socket the metrics are served on, instead of TCP unless a host,
+	// port or listen address is set as well
+	SocketPath string `yaml:"socket_path"`
+	// Permissions of the socket file (0 = as created, following the umask)
+	SocketMode FileMode `yaml:"socket_mode"`
+	// Constant labels added to every metric, e.g. region or environment
+	Labels map[string]string `yaml:"labels"`
 }
 
 // MonitorConfig contains monitoring configuration
 type MonitorConfig struct {
-	Interval time.Duration `yaml:"interval"`
-	Timeout  time.Duration `yaml:"timeout"`
+	Interval        Duration `yaml:"interval"`
+	Timeout         Duration `yaml:"timeout"`
+	EDNSBufferSize  uint16   `yaml:"edns_buffer_size"`
+	NSID            bool     `yaml:"nsid"`
+	Cookies         bool     `yaml:"cookies"`
+	ChaosQueries    []string `yaml:"chaos_queries"`
+	MaxCNAMEDepth   int      `yaml:"max_cname_depth"`
+	MaxExportedIPs  int      `yaml:"max_exported_ips"`
+	QueriesPerProbe int      `yaml:"queries_per_probe"`
+	// Name whose SOA record is queried to check each server is up
+	HealthCheckQuery  string `yaml:"health_check_query"`
+	CaseRandomization bool   `yaml:"case_randomization"`
+	SourceAddress     string `yaml:"source_address"`
+	ProxyURL          string `yaml:"proxy_url"`
+	// Series of probes not performed for this long are deleted (0 = kept),
+	// must be longer than the longest interval
+	MetricStalenessTTL Duration `yaml:"metric_staleness_ttl"`
+	// Queries repeated after a failed one within the timeout, waiting
+	// retry_backoff before the first retry and twice as long before each
+	// further one, for failures of the error classes in retry_on
+	Retries      int      `yaml:"retries"`
+	RetryBackoff Duration `yaml:"retry_backoff"`
+	RetryOn      []string `yaml:"retry_on"`
+	// Domains appended to relative target names, tried in order until one
+	// resolves, and the number of dots from which a name is tried as given
+	// first (default 1), as in resolv.conf
+	SearchDomains []string `yaml:"search_domains"`
+	NDots         *int     `yaml:"ndots"`
+	// Maximum random delay of each cycle's targets from the cycle's
+	// scheduled start, so exporters started together do not probe in step
+	Jitter Jitter `yaml:"jitter"`
+	// What happens to cycles due while a cycle overruns the interval:
+	// "skip" (default), "queue" or "overlap"
+	OverrunPolicy string `yaml:"overrun_policy"`
+	// What the dns_server label holds: "address" (default) or "name"
+	DNSServerLabel string `yaml:"dns_server_label"`
+	// Form of internationalized names in the fqdn label: "unicode"
+	// (default) or "ascii" (punycode), queries always use the latter
+	IDNLabel string `yaml:"idn_label"`
+	// Buckets of the response duration histogram, in seconds
+	LatencyBuckets []float64 `yaml:"latency_buckets"`
+	// Also give the response duration histograms native buckets
+	NativeHistograms bool `yaml:"native_histograms"`
+	// Only export the histogram, not the last response time gauge
+	LatencyHistogramOnly bool `yaml:"latency_histogram_only"`
+	// Only export the per-server response duration histogram, none of the
+	// per-fqdn response time metrics
+	DisableFQDNLatency bool `yaml:"disable_fqdn_latency"`
+	// Attach the trace ID of each probe as exemplar to the response
+	// duration histograms, served with OpenMetrics only
+	LatencyExemplars bool `yaml:"latency_exemplars"`
+	// Quantiles of the response duration summary and their allowed error,
+	// the summary is only exported when set
+	LatencyQuantiles map[float64]float64 `yaml:"latency_quantiles"`
+	// Window the quantiles are computed over (default 10 intervals)
+	LatencyQuantilesMaxAge Duration `yaml:"latency_quantiles_max_age"`
+	// Window the availability ratio is computed over (default 15m)
+	AvailabilityWindow Duration `yaml:"availability_window"`
+	// Weight of the newest response time in the moving average (default 0.3)
+	ResponseTimeEWMAAlpha float64 `yaml:"response_time_ewma_alpha"`
+	// Maintenance windows of all targets
+	MaintenanceWindows []MaintenanceWindow `yaml:"maintenance_windows"`
 }
 
 // DNSServer represents a DNS server configuration
 type DNSServer struct {
-	Name    string `yaml:"name"`
-	Address string `yaml:"address"`
+	Name           string `yaml:"name"`
+	Address        string `yaml:"address"`
+	EDNSBufferSize uint16 `yaml:"edns_buffer_size"`
+	NSID           bool   `yaml:"nsid"`
+	Cookies        bool   `yaml:"cookies"`
+	Protocol       string `yaml:"protocol"`
+	// Superseded by tls.server_name and tls.ca_file, used when those are
+	// not set
+	TLSServerName string `yaml:"tls_server_name"`
+	TLSCAFile     string `yaml:"tls_ca_file"`
+	// Certificate validation and client certificate for dot, doq and doh
+	TLS TLSConfig `yaml:"tls"`
+	// Open a new QUIC connection for every DoQ query
+	DoQFreshConnection bool `yaml:"doq_fresh_connection"`
+	// Local IP address queries to the server are sent from
+	SourceAddress string `yaml:"source_address"`
+	// SOCKS5 proxy for tcp, dot and doh servers
+	ProxyURL string `yaml:"proxy_url"`
+	// Address family the server is reached over: ipv4, ipv6 or any
+	TransportFamily string `yaml:"transport_family"`
+	// Extra HTTP headers and bearer token file for doh servers
+	Headers         map[string]string `yaml:"headers"`
+	BearerTokenFile string            `yaml:"bearer_token_file"`
+	// Headers whose values are read from files for every query
+	HeaderFiles map[string]string `yaml:"header_files"`
+	// Name whose SOA record is queried to check the server is up
+	HealthCheckQuery string `yaml:"health_check_query"`
+	// Query timeout of the server instead of monitoring.timeout, answers
+	// slower than this are failures
+	Timeout Duration `yaml:"timeout"`
+	// Tags targets select the server by with server_tags
+	Tags []string `yaml:"tags"`
+
+	// Job of the server, "" for top-level servers
+	Job string `yaml:"-"`
+	// Setting the server was read from, for error messages ("" =
+	// dns_servers[i])
+	path string
+}
+
+// field returns the setting the i-th server was read from
+func (s DNSServer) field(i int) string {
+	if s.path != "" {
+		return s.path
+	}
+	return fmt.Sprintf("dns_servers[%d]", i)
+}
+
+// TLSConfig contains the TLS settings of a DNS server
+type TLSConfig struct {
+	CAFile             string `yaml:"ca_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	// Client certificate for resolvers requiring mutual TLS
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
 }
 
 // Target represents a DNS resolution target
 type Target struct {
-	FQDN        string   `yaml:"fqdn"`
+	FQDN              string   `yaml:"fqdn"`
+	RecordTypes       []string `yaml:"record_types"`
+	ClientSubnets     []string `yaml:"client_subnet"`
+	CaseRandomization bool     `yaml:"case_randomization"`
+	Expect            string   `yaml:"expect"`
+	Trace             bool     `yaml:"trace"`
+	TraceStartZone    string   `yaml:"trace_start_zone"`
+	TraceStartServers []string `yaml:"trace_start_servers"`
+	CheckDelegation   bool     `yaml:"check_delegation"`
+	WildcardCheck     bool     `yaml:"wildcard_check"`
+	ParentZone        string   `yaml:"parent_zone"`
+	MaxExportedIPs    int      `yaml:"max_exported_ips"`
+	QueriesPerProbe   int      `yaml:"queries_per_probe"`
+	// Response time above which a successful lookup breaches the SLO
+	LatencySLO Duration `yaml:"latency_slo"`
+	// Retries of failed queries instead of monitoring.retries, 0 disables
+	// them for the target
+	Retries      *int     `yaml:"retries"`
+	RetryBackoff Duration `yaml:"retry_backoff"`
+	// Query class: IN (default), CH or HS
+	Class string `yaml:"class"`
+	// Probe interval of the target instead of monitoring.interval
+	Interval Duration `yaml:"interval"`
+	// Whether the target is probed, true when not set
+	Enabled *bool `yaml:"enabled"`
+	// Maintenance windows of the target in addition to the global ones
+	MaintenanceWindows []MaintenanceWindow `yaml:"maintenance_windows"`
+	// Query only the servers with these names or tags (default all)
+	DNSServers []string `yaml:"dns_servers"`
+	ServerTags []string `yaml:"server_tags"`
+	// Extra labels added to every metric of the target
+	Labels map[string]string `yaml:"labels"`
+
+	// Job of the target, "" for top-level targets
+	Job string `yaml:"-"`
+	// FQDN as written in the configuration, when normalizing changed it
+	spelling string
+	// Whether the FQDN was written with a trailing dot, bypassing the
+	// search domains
+	absolute bool
+	// Zone of the target's job, "" to use defaults.zone
+	zone string
+	// A-label form of an internationalized FQDN, "" when it is queried as
+	// labeled
+	name string
+	// Setting the target was read from, for error messages ("" =
+	// targets[i])
+	path string
+}
+
+// IsEnabled reports whether the target is probed. Disabled targets stay in
+// the configuration but are not probed and have no metrics.
+func (t Target) IsEnabled() bool {
+	return t.Enabled == nil || *t.Enabled
+}
+
+// QueriesServer reports whether the target is queried against the server
+// with name, job and tags: the servers of the target's job, restricted to
+// those named in dns_servers or tagged with one of server_tags when the
+// target sets either.
+func (t Target) QueriesServer(name, job string, tags []string) bool {
+	if job != t.Job {
+		return false
+	}
+	if len(t.DNSServers) == 0 && len(t.ServerTags) == 0 {
+		return true
+	}
+	if slices.Contains(t.DNSServers, name) {
+		return true
+	}
+	for _, tag := range tags {
+		if slices.Contains(t.ServerTags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Spelling returns the name of the target as written in the configuration
+func (t Target) Spelling() string {
+	if t.spelling != "" {
+		return t.spelling
+	}
+	return t.FQDN
+}
+
+// QueryName returns the name queried for the target, the A-label form of
+// internationalized names
+func (t Target) QueryName() string {
+	if t.name != "" {
+		return t.name
+	}
+	return t.FQDN
+}
+
+// normalizeFQDN returns the form names are queried and labeled by, in lower
+// case and without trailing dot, the root zone staying ".". Queries sent to
+// servers are for the absolute name regardless.
+func normalizeFQDN(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name != "." {
+		name = strings.TrimSuffix(name, ".")
+	}
+	return name
+}
+
+// field returns the setting the i-th target was read from
+func (t Target) field(i int) string {
+	if t.path != "" {
+		return t.path
+	}
+	return fmt.Sprintf("targets[%d]", i)
+}
+
+// TargetDefaults contains the settings used by targets that omit them
+type TargetDefaults struct {
 	RecordTypes []string `yaml:"record_types"`
+	// Zone appended to target names that are not fully qualified, see
+	// Job.Zone
+	Zone string `yaml:"zone"`
+}
+
+// defaultRecordTypes are queried for targets without record types when
+// defaults.record_types is not set either
+var defaultRecordTypes = []string{"A"}
+
+// retryErrorClasses lists the error classes monitoring.retry_on accepts,
+// the error_class label values of failed lookups
+var retryErrorClasses = []string{"timeout", "network", "nxdomain", "servfail", "refused", "tls", "proxy", "cname", "other"}
+
+// defaultRetryOn are the error classes retried when retry_on is not set,
+// those where no answer was received
+var defaultRetryOn = []string{"timeout", "network"}
+
+// Values of MonitorConfig.OverrunPolicy
+const (
+	// OverrunPolicySkip drops the cycles that became due during an overrun
+	OverrunPolicySkip = "skip"
+	// OverrunPolicyQueue runs the cycles that became due back to back
+	OverrunPolicyQueue = "queue"
+	// OverrunPolicyOverlap starts every cycle on time, alongside a running one
+	OverrunPolicyOverlap = "overlap"
+)
+
+// Values of MonitorConfig.DNSServerLabel
+const (
+	DNSServerLabelAddress = "address"
+	DNSServerLabelName    = "name"
+)
+
+// ExpectNXDomain is the Target.Expect value for names that must not resolve
+const ExpectNXDomain = "nxdomain"
+
+// ZoneTransfer represents an AXFR check of a zone against a server
+type ZoneTransfer struct {
+	Zone          string   `yaml:"zone"`
+	Server        string   `yaml:"server"`
+	Timeout       Duration `yaml:"timeout"`
+	TSIGKeyName   string   `yaml:"tsig_key_name"`
+	TSIGAlgorithm string   `yaml:"tsig_algorithm"`
+	TSIGSecret    string   `yaml:"tsig_secret"`
+	// File the secret is read from for each transfer, instead of
+	// tsig_secret
+	TSIGSecretFile string `yaml:"tsig_secret_file"`
+}
+
+// protocols lists the supported DNS server transport protocols
+var protocols = []string{"do53", "udp", "tcp", "dot", "doq", "doh"}
+
+// SystemAddress is the dns_servers address that resolves through the host's
+// own resolver configuration
+const SystemAddress = "system"
+
+// transportFamilies lists the supported DNS server address families
+var transportFamilies = []string{"ipv4", "ipv6", "any"}
+
+// tlsProtocols lists the protocols secured with TLS
+var tlsProtocols = []string{"dot", "doq", "doh"}
+
+// proxyProtocols lists the protocols whose connections can go through a
+// SOCKS5 proxy, or for doh also an HTTP(S) proxy
+var proxyProtocols = []string{"tcp", "dot", "doh"}
+
+// httpProxySchemes lists the proxy_url schemes of HTTP proxies, which only
+// DoH servers are queried through
+var httpProxySchemes = []string{"http", "https"}
+
+// defaultLatencyBuckets are the response duration histogram buckets used
+// when latency_buckets is not set, from 1ms to 10s
+var defaultLatencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// tsigAlgorithms lists the supported TSIG algorithms
+var tsigAlgorithms = []string{"hmac-sha1", "hmac-sha224", "hmac-sha256", "hmac-sha384", "hmac-sha512"}
+
+// LoadOptions control how LoadConfig reads the configuration file
+type LoadOptions struct {
+	// Replace ${VAR} and ${VAR:-default} with environment variables
+	ExpandEnv bool
+	// Ignore unknown settings instead of failing on them
+	Lenient bool
+
+	// Override the file and the environment when not zero, e.g. given on
+	// the command line
+	ListenAddress string
+	Port          int
+	Interval      Duration
+	Timeout       Duration
 }
 
-// LoadConfig loads configuration from YAML file
-func LoadConfig(filename string) (*Config, error) {
+// apply overrides the settings of config that are set in the options
+func (o LoadOptions) apply(config *Config) {
+	if o.ListenAddress != "" {
+		config.Server.ListenAddress = o.ListenAddress
+		config.Server.Host = ""
+		config.Server.Port = 0
+	}
+	if o.Port != 0 {
+		// Only the port of a listen address is replaced
+		if host, _, err := net.SplitHostPort(config.Server.ListenAddress); err == nil {
+			config.Server.ListenAddress = net.JoinHostPort(host, strconv.Itoa(o.Port))
+		} else {
+			config.Server.Port = o.Port
+		}
+	}
+	if o.Interval != 0 {
+		config.Monitoring.Interval = o.Interval
+	}
+	if o.Timeout != 0 {
+		config.Monitoring.Timeout = o.Timeout
+	}
+}
+
+// LoadConfig loads configuration from a YAML, JSON or TOML file
+func LoadConfig(filename string, opts LoadOptions) (*Config, error) {
+	// The environment variables alone configure the exporter when there is
+	// no file
 	data, err := os.ReadFile(filename)
-	if err != nil {
+	if err != nil && !(errors.Is(err, fs.ErrNotExist) && envConfigured()) {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
+	// Expanded before parsing, so references work in any field, and before
+	// hashing, so the hash changes with the values in effect
+	if opts.ExpandEnv {
+		data, err = expandEnv(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand config file: %w", err)
+		}
+	}
+
 	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
+	if err := unmarshalConfig(fileFormat(filename, data), data, &config, !opts.Lenient); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
-
-	// Set default values if not specified
-	if config.Server.Port == 0 {
-		config.Server.Port = 9653
+	if err := config.applyEnv(); err != nil {
+		return nil, fmt.Errorf("failed to apply environment variables: %w", err)
 	}
-	if config.Monitoring.Interval == 0 {
-		config.Monitoring.Interval = 30 * time.Second
+	opts.apply(&config)
+	hash := sha256.New()
+	hash.Write(data)
+	writeEnv(hash)
+	fmt.Fprintf(hash, "%s %d %v %v\n", opts.ListenAddress, opts.Port, opts.Interval, opts.Timeout)
+	config.Hash = fmt.Sprintf("%x", hash.Sum(nil))
+
+	if config.DNSServersFromResolvConf {
+		if err := config.addResolvConfServers(); err != nil {
+			return nil, err
+		}
 	}
-	if config.Monitoring.Timeout == 0 {
-		config.Monitoring.Timeout = 10 * time.Second
+
+	config.addJobs()
+	config.setDefaults()
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
 	}
 
 	return &config, nil
 }
 
-// GetListenAddress returns the server listen address
+// setDefaults fills in the settings that are not specified
+func (c *Config) setDefaults() {
+	// With a socket, TCP is only served when asked for
+	if c.Server.Port == 0 && c.Server.ListenAddress == "" && (c.Server.SocketPath == "" || c.Server.Host != "") {
+		c.Server.Port = 9653
+	}
+	if c.Monitoring.Interval == 0 {
+		c.Monitoring.Interval = Duration(30 * time.Second)
+	}
+	// The default timeout leaves short intervals valid
+	if c.Monitoring.Timeout == 0 {
+		c.Monitoring.Timeout = min(Duration(10*time.Second), c.Monitoring.Interval)
+	}
+	// With several probes per age bucket the quantiles follow the recent
+	// probes without jumping on every single one
+	if len(c.Monitoring.LatencyQuantiles) > 0 && c.Monitoring.LatencyQuantilesMaxAge == 0 {
+		c.Monitoring.LatencyQuantilesMaxAge = 10 * c.Monitoring.Interval
+	}
+	if len(c.Monitoring.LatencyBuckets) == 0 {
+		c.Monitoring.LatencyBuckets = defaultLatencyBuckets
+	}
+	if c.Monitoring.ResponseTimeEWMAAlpha == 0 {
+		c.Monitoring.ResponseTimeEWMAAlpha = 0.3
+	}
+	if c.Monitoring.AvailabilityWindow == 0 {
+		c.Monitoring.AvailabilityWindow = Duration(15 * time.Minute)
+	}
+	for i, domain := range c.Monitoring.SearchDomains {
+		c.Monitoring.SearchDomains[i] = normalizeFQDN(domain)
+	}
+	if len(c.Monitoring.RetryOn) == 0 {
+		c.Monitoring.RetryOn = slices.Clone(defaultRetryOn)
+	}
+	if c.Monitoring.OverrunPolicy == "" {
+		c.Monitoring.OverrunPolicy = OverrunPolicySkip
+	}
+	if c.Monitoring.IDNLabel == "" {
+		c.Monitoring.IDNLabel = IDNLabelUnicode
+	}
+	// Spellings of the same name share their metrics, which are labeled
+	// with the normalized name, qualified with the zone of the target's
+	// job or the defaults section. Internationalized names are labeled in
+	// the form chosen by idn_label, invalid ones are left to Validate.
+	for i := range c.Targets {
+		target := &c.Targets[i]
+		target.absolute = strings.HasSuffix(strings.TrimSpace(target.FQDN), ".")
+		normalized := normalizeFQDN(target.FQDN)
+		if zone := normalizeFQDN(cmp.Or(target.zone, c.Defaults.Zone)); zone != "" {
+			normalized = qualifyName(normalized, zone, target.absolute)
+			// Names within the zone are qualified, not completed with
+			// the search domains
+			target.absolute = true
+		}
+		if ascii, unicode, err := idnNames(normalized); err == nil && ascii != unicode {
+			target.name = ascii
+			normalized = unicode
+			if c.Monitoring.IDNLabel == IDNLabelASCII {
+				normalized = ascii
+			}
+		}
+		if normalized != target.FQDN {
+			target.spelling = target.FQDN
+			target.FQDN = normalized
+		}
+	}
+	for i := range c.Targets {
+		c.Targets[i].Class = normalizeClass(c.Targets[i].Class)
+	}
+	// A target's own record types, or its job's, take precedence over the
+	// defaults section, which takes precedence over the built-in default
+	recordTypes := c.Defaults.RecordTypes
+	if len(recordTypes) == 0 {
+		recordTypes = defaultRecordTypes
+	}
+	for i := range c.Targets {
+		if len(c.Targets[i].RecordTypes) == 0 {
+			c.Targets[i].RecordTypes = slices.Clone(recordTypes)
+		}
+	}
+	for i := range c.ZoneTransfers {
+		zt := &c.ZoneTransfers[i]
+		if zt.Timeout == 0 {
+			zt.Timeout = Duration(60 * time.Second)
+		}
+		if zt.TSIGKeyName != "" && zt.TSIGAlgorithm == "" {
+			zt.TSIGAlgorithm = "hmac-sha256"
+		}
+	}
+}
+
+// checkServerAddress rejects DNS server addresses that are neither an IP
+// address nor a host name, each optionally followed by a port. IPv6
+// addresses may be bracketed, and must be when a port is given.
+func checkServerAddress(address string) error {
+	if address == "" {
+		return fmt.Errorf("address is required")
+	}
+
+	host := address
+	if h, port, err := net.SplitHostPort(address); err == nil {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid address %q: invalid port %q", address, port)
+		}
+		host = h
+	} else if strings.HasPrefix(address, "[") {
+		host = strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+		if !strings.HasSuffix(address, "]") || net.ParseIP(host) == nil {
+			return fmt.Errorf("invalid address %q: not an IPv6 address", address)
+		}
+	}
+
+	if net.ParseIP(host) != nil || isHostname(host) {
+		return nil
+	}
+	return fmt.Errorf("invalid address %q: not an IP address or host name", address)
+}
+
+// reservedLabels lists the label names of the exported metrics, which
+// constant labels must not use
+var reservedLabels = []string{
+	"address", "algorithm", "class", "client_subnet", "digest_type", "dns_server",
+	"error", "error_class", "exchange", "flags", "fqdn", "hash", "hostname",
+	"ip_address", "key_tag", "le", "missing", "name", "nameserver", "ns",
+	"nsid", "param", "port", "priority", "protocol", "quantile", "query",
+	"rcode", "record_type", "resolved_fqdn", "server", "status", "target",
+	"transport_family", "value", "zone",
+}
+
+// labelNamePattern matches valid Prometheus label names
+var labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// checkConstLabel verifies that name can be used as a constant label
+func checkConstLabel(name string) error {
+	if !labelNamePattern.MatchString(name) || strings.HasPrefix(name, "__") {
+		return fmt.Errorf("invalid label name %q", name)
+	}
+	if slices.Contains(reservedLabels, name) {
+		return fmt.Errorf("label %q collides with a label of the exported metrics", name)
+	}
+	return nil
+}
+
+// checkSystemServer rejects settings that need a connection of our own, which
+// the system resolver does not use
+func checkSystemServer(server DNSServer) error {
+	if server.Protocol != "" && server.Protocol != "do53" {
+		return fmt.Errorf("protocol %q is not supported with address %q", server.Protocol, SystemAddress)
+	}
+	for option, value := range map[string]string{
+		"source_address":   server.SourceAddress,
+		"proxy_url":        server.ProxyURL,
+		"transport_family": server.TransportFamily,
+	} {
+		if value != "" {
+			return fmt.Errorf("%s is not supported with address %q", option, SystemAddress)
+		}
+	}
+	return nil
+}
+
+// checkDoHURL verifies that the address of a doh server is an https URL
+func checkDoHURL(address string) error {
+	u, err := url.Parse(address)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("invalid address %q: doh servers need an https:// URL", address)
+	}
+	return nil
+}
+
+// isHostname reports whether name is a syntactically valid host name. Names
+// ending in an all-numeric label are rejected since they are mistyped IPv4
+// addresses rather than host names.
+func isHostname(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > 253 {
+		return false
+	}
+
+	labels := strings.Split(name, ".")
+	for _, label := range labels {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+				return false
+			}
+		}
+	}
+
+	last := labels[len(labels)-1]
+	return strings.Trim(last, "0123456789") != ""
+}
+
+// checkSourceAddress verifies that a source_address is an IP address
+// assigned to this host, so a typo fails at startup instead of every query
+func checkSourceAddress(address string) error {
+	if address == "" {
+		return nil
+	}
+
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return fmt.Errorf("invalid source_address %q: not an IP address", address)
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return fmt.Errorf("failed to list interface addresses: %w", err)
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid source_address %s: not assigned to any interface of this host", address)
+}
+
+// checkSecretFile verifies that a file holding a secret can be read and is
+// not empty. The secret itself is read again when it is used, and never
+// included in errors.
+func checkSecretFile(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		return fmt.Errorf("%s is empty", path)
+	}
+	return nil
+}
+
+// checkListenAddress verifies that a listen address has the host:port form
+// with a valid port, IPv6 hosts in brackets
+func checkListenAddress(address string) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("invalid address %q: %v", address, err)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid address %q: %q is not a valid port", address, port)
+	}
+	return nil
+}
+
+// checkProxyURL verifies that a proxy_url is a SOCKS5 or HTTP(S) URL
+func checkProxyURL(proxyURL string) error {
+	if proxyURL == "" {
+		return nil
+	}
+
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return fmt.Errorf("invalid proxy_url: %w", err)
+	}
+	if u.Scheme != "socks5" && u.Scheme != "socks5h" && !slices.Contains(httpProxySchemes, u.Scheme) {
+		return fmt.Errorf("invalid proxy_url: unsupported scheme %q, supported are socks5, http and https", u.Scheme)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid proxy_url: missing host")
+	}
+	return nil
+}
+
+// proxyScheme returns the scheme of a proxy_url, "" when it is not set or
+// invalid
+func proxyScheme(proxyURL string) string {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return ""
+	}
+	return u.Scheme
+}
+
+// ServesTCP reports whether the metrics are served on TCP, which is not the
+// case when only a socket is configured
+func (c *Config) ServesTCP() bool {
+	return c.Server.ListenAddress != "" || c.Server.Port != 0
+}
+
+// GetListenAddress returns the server listen address in the host:port form
 func (c *Config) GetListenAddress() string {
-	return fmt.Sprintf(":%d", c.Server.Port)
+	if c.Server.ListenAddress != "" {
+		return c.Server.ListenAddress
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(c.Server.Host, "["), "]")
+	return net.JoinHostPort(host, strconv.Itoa(c.Server.Port))
+}
+
+// GetEDNSBufferSize returns the EDNS0 UDP buffer size used for queries to
+// server. The per-server setting takes precedence over the global one; 0
+// means queries are sent without EDNS0.
+func (c *Config) GetEDNSBufferSize(server DNSServer) uint16 {
+	if server.EDNSBufferSize != 0 {
+		return server.EDNSBufferSize
+	}
+	return c.Monitoring.EDNSBufferSize
+}
+
+// GetMaxExportedIPs returns how many resolved addresses of target are
+// exported as dns_resolved_ip_address series. The per-target setting takes
+// precedence over the global one; 0 means all addresses are exported.
+func (c *Config) GetMaxExportedIPs(target Target) int {
+	if target.MaxExportedIPs != 0 {
+		return target.MaxExportedIPs
+	}
+	return c.Monitoring.MaxExportedIPs
+}
+
+// GetInterval returns how often target is probed. The per-target setting
+// takes precedence over the global one.
+func (c *Config) GetInterval(target Target) time.Duration {
+	if target.Interval != 0 {
+		return time.Duration(target.Interval)
+	}
+	return time.Duration(c.Monitoring.Interval)
+}
+
+// GetJitter returns the maximum random delay of probes of cycles at
+// interval, see MonitorConfig.Jitter
+func (c *Config) GetJitter(interval time.Duration) time.Duration {
+	return c.Monitoring.Jitter.Of(interval)
+}
+
+// GetRetries returns how often a failed query of target is retried. The
+// per-target setting takes precedence over the global one.
+func (c *Config) GetRetries(target Target) int {
+	if target.Retries != nil {
+		return *target.Retries
+	}
+	return c.Monitoring.Retries
+}
+
+// GetRetryBackoff returns the wait before the first retry of a query of
+// target. The per-target setting takes precedence over the global one.
+func (c *Config) GetRetryBackoff(target Target) time.Duration {
+	if target.RetryBackoff != 0 {
+		return time.Duration(target.RetryBackoff)
+	}
+	return time.Duration(c.Monitoring.RetryBackoff)
+}
+
+// GetQueriesPerProbe returns how many queries are sent for each record type
+// of target to each server per cycle. The per-target setting takes
+// precedence over the global one; the default is a single query.
+func (c *Config) GetQueriesPerProbe(target Target) int {
+	if target.QueriesPerProbe != 0 {
+		return target.QueriesPerProbe
+	}
+	return max(c.Monitoring.QueriesPerProbe, 1)
+}
+
+// GetHealthCheckQuery returns the name whose SOA record is queried to check
+// server is up. The per-server setting takes precedence over the global
+// one; "" means the root zone.
+func (c *Config) GetHealthCheckQuery(server DNSServer) string {
+	if server.HealthCheckQuery != "" {
+		return server.HealthCheckQuery
+	}
+	return c.Monitoring.HealthCheckQuery
+}
+
+// GetTimeout returns the timeout of the queries to server. The per-server
+// setting takes precedence over the global one.
+func (c *Config) GetTimeout(server DNSServer) time.Duration {
+	if server.Timeout != 0 {
+		return time.Duration(server.Timeout)
+	}
+	return time.Duration(c.Monitoring.Timeout)
+}
+
+// TargetLabels returns the extra labels of the targets by fqdn and the
+// sorted union of their names, including the class label of targets not
+// queried in class IN. Every metric of a target carries all names, those
+// the target does not set with an empty value.
+func (c *Config) TargetLabels() (map[string]map[string]string, []string) {
+	labels := make(map[string]map[string]string)
+	var names []string
+	for _, target := range c.Targets {
+		targetLabels := target.Labels
+		if target.Class != "" && target.Class != ClassIN {
+			targetLabels = maps.Clone(target.Labels)
+			if targetLabels == nil {
+				targetLabels = make(map[string]string)
+			}
+			targetLabels[ClassLabel] = target.Class
+		}
+		for name, value := range targetLabels {
+			if labels[target.FQDN] == nil {
+				labels[target.FQDN] = make(map[string]string)
+			}
+			labels[target.FQDN][name] = value
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return labels, names
+}
+
+// GetNSID reports whether queries to server request the NSID option, either
+// because it is enabled globally or for the server
+func (c *Config) GetNSID(server DNSServer) bool {
+	return c.Monitoring.NSID || server.NSID
+}
+
+// GetCookies reports whether queries to server carry DNS cookies, either
+// because they are enabled globally or for the server
+func (c *Config) GetCookies(server DNSServer) bool {
+	return c.Monitoring.Cookies || server.Cookies
+}
+
+// GetTLSConfig builds the TLS client configuration for a DoT, DoQ or DoH
+// server from its tls settings. The client certificate is loaded again for
+// every handshake, so replaced certificate files are picked up.
+func (c *Config) GetTLSConfig(server DNSServer) (*tls.Config, error) {
+	serverName := server.TLS.ServerName
+	if serverName == "" {
+		serverName = server.TLSServerName
+	}
+	caFile := server.TLS.CAFile
+	if caFile == "" {
+		caFile = server.TLSCAFile
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         serverName,
+		InsecureSkipVerify: server.TLS.InsecureSkipVerify,
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tls ca_file: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in tls ca_file %s", caFile)
+		}
+	}
+
+	if server.TLS.CertFile != "" {
+		certFile, keyFile := server.TLS.CertFile, server.TLS.KeyFile
+		if _, err := tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+			return nil, fmt.Errorf("failed to load tls client certificate: %w", err)
+		}
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				return nil, err
+			}
+			return &cert, nil
+		}
+	}
+	return tlsConfig, nil
+}
+
+// GetSourceAddress returns the local address queries to server are sent
+// from. The per-server setting takes precedence over the global one.
+func (c *Config) GetSourceAddress(server DNSServer) string {
+	if server.SourceAddress != "" {
+		return server.SourceAddress
+	}
+	return c.Monitoring.SourceAddress
+}
+
+// GetProxyURL returns the proxy connections to server go through, or nil
+// for direct connections. The per-server setting takes precedence over the
+// global one, which only applies to servers queried over tcp, dot or doh
+// (proxyProtocols). A global HTTP(S) proxy is only supported by doh
+// servers, tcp and dot servers then need a SOCKS5 proxy_url of their own.
+func (c *Config) GetProxyURL(server DNSServer) *url.URL {
+	proxyURL := server.ProxyURL
+	if proxyURL == "" && slices.Contains(proxyProtocols, server.Protocol) {
+		proxyURL = c.Monitoring.ProxyURL
+	}
+	if proxyURL == "" {
+		return nil
+	}
+
+	// Validated by LoadConfig
+	u, _ := url.Parse(proxyURL)
+	return u
+}
+
+// GetCaseRandomization reports whether queries for target randomize the case
+// of the query name, either because it is enabled globally or for the target
+func (c *Config) GetCaseRandomization(target Target) bool {
+	return c.Monitoring.CaseRandomization || target.CaseRandomization
 }
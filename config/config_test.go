@@ -0,0 +1,225 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeConfig writes content to a configuration file of the test
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTimeoutPrecedence(t *testing.T) {
+	tests := []struct {
+		name          string
+		fileTimeout   string
+		envTimeout    string
+		flagTimeout   time.Duration
+		serverTimeout string
+		want          time.Duration
+	}{
+		{"default", "", "", 0, "", 10 * time.Second},
+		{"file", "4s", "", 0, "", 4 * time.Second},
+		{"env over file", "4s", "3s", 0, "", 3 * time.Second},
+		{"flag over env", "4s", "3s", 2 * time.Second, "", 2 * time.Second},
+		{"flag over file", "4s", "", 2 * time.Second, "", 2 * time.Second},
+		{"server over flag", "4s", "3s", 2 * time.Second, "1s", time.Second},
+		{"server over file", "4s", "", 0, "1s", time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := "monitoring:\n  interval: 30s\n"
+			if tt.fileTimeout != "" {
+				content += "  timeout: " + tt.fileTimeout + "\n"
+			}
+			content += "dns_servers:\n  - address: 1.1.1.1\n"
+			if tt.serverTimeout != "" {
+				content += "    timeout: " + tt.serverTimeout + "\n"
+			}
+			content += "targets:\n  - fqdn: example.com\n"
+			if tt.envTimeout != "" {
+				t.Setenv(EnvTimeout, tt.envTimeout)
+			}
+			cfg, err := LoadConfig(writeConfig(t, content), LoadOptions{Timeout: Duration(tt.flagTimeout)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cfg.GetTimeout(cfg.DNSServers[0]); got != tt.want {
+				t.Errorf("GetTimeout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJobTimeoutPrecedence(t *testing.T) {
+	t.Setenv(EnvTimeout, "3s")
+	cfg, err := LoadConfig(writeConfig(t, `
+monitoring:
+  interval: 30s
+  timeout: 4s
+dns_servers:
+  - address: 1.1.1.1
+targets:
+  - fqdn: example.com
+jobs:
+  - name: internal
+    timeout: 5s
+    dns_servers:
+      - name: job-default
+        address: 10.0.0.53
+      - name: job-server
+        address: 10.0.0.54
+        timeout: 1s
+    targets:
+      - fqdn: internal.example.com
+`), LoadOptions{Timeout: Duration(2 * time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The job's timeout applies to its servers only, and wins over the
+	// flag like a server's own timeout
+	want := map[string]time.Duration{"1.1.1.1": 2 * time.Second, "job-default": 5 * time.Second, "job-server": time.Second}
+	for _, server := range cfg.DNSServers {
+		name := server.Name
+		if name == "" {
+			name = server.Address
+		}
+		if got := cfg.GetTimeout(server); got != want[name] {
+			t.Errorf("GetTimeout(%s) = %v, want %v", name, got, want[name])
+		}
+	}
+}
+
+func TestIntervalPrecedence(t *testing.T) {
+	tests := []struct {
+		name           string
+		fileInterval   string
+		envInterval    string
+		flagInterval   time.Duration
+		targetInterval string
+		want           time.Duration
+	}{
+		{"default", "", "", 0, "", 30 * time.Second},
+		{"file", "40s", "", 0, "", 40 * time.Second},
+		{"env over file", "40s", "50", 0, "", 50 * time.Second},
+		{"flag over env", "40s", "50s", time.Minute, "", time.Minute},
+		{"target over flag", "40s", "50s", time.Minute, "20s", 20 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := "monitoring:\n  timeout: 5s\n"
+			if tt.fileInterval != "" {
+				content += "  interval: " + tt.fileInterval + "\n"
+			}
+			content += "dns_servers:\n  - address: 1.1.1.1\ntargets:\n  - fqdn: example.com\n"
+			if tt.targetInterval != "" {
+				content += "    interval: " + tt.targetInterval + "\n"
+			}
+			if tt.envInterval != "" {
+				t.Setenv(EnvInterval, tt.envInterval)
+			}
+			cfg, err := LoadConfig(writeConfig(t, content), LoadOptions{Interval: Duration(tt.flagInterval)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cfg.GetInterval(cfg.Targets[0]); got != tt.want {
+				t.Errorf("GetInterval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordTypesPrecedence(t *testing.T) {
+	tests := []struct {
+		name     string
+		defaults string
+		want     map[string][]string
+	}{
+		{"built-in default", "", map[string][]string{
+			"top.example.com":      {"A"},
+			"top-mx.example.com":   {"MX"},
+			"job.example.com":      {"TXT"},
+			"job-aaaa.example.com": {"AAAA"},
+			"other.example.com":    {"A"},
+			"other-ns.example.com": {"NS"},
+		}},
+		{"defaults section", "defaults:\n  record_types: [A, AAAA]\n", map[string][]string{
+			"top.example.com":      {"A", "AAAA"},
+			"top-mx.example.com":   {"MX"},
+			"job.example.com":      {"TXT"},
+			"job-aaaa.example.com": {"AAAA"},
+			"other.example.com":    {"A", "AAAA"},
+			"other-ns.example.com": {"NS"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadConfig(writeConfig(t, tt.defaults+`
+dns_servers:
+  - address: 1.1.1.1
+targets:
+  - fqdn: top.example.com
+  - fqdn: top-mx.example.com
+    record_types: [MX]
+jobs:
+  - name: with-types
+    record_types: [TXT]
+    dns_servers:
+      - address: 10.0.0.53
+    targets:
+      - fqdn: job.example.com
+      - fqdn: job-aaaa.example.com
+        record_types: [AAAA]
+  - name: without-types
+    dns_servers:
+      - address: 10.0.0.54
+    targets:
+      - fqdn: other.example.com
+      - fqdn: other-ns.example.com
+        record_types: [NS]
+`), LoadOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, target := range cfg.Targets {
+				if !reflect.DeepEqual(target.RecordTypes, tt.want[target.FQDN]) {
+					t.Errorf("record types of %s = %v, want %v", target.FQDN, target.RecordTypes, tt.want[target.FQDN])
+				}
+			}
+		})
+	}
+}
+
+func TestListenAddressOverride(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		opts LoadOptions
+		want string
+	}{
+		{"port over file port", "server:\n  port: 9000\n", LoadOptions{Port: 9100}, ":9100"},
+		{"port keeps the listen address host", "server:\n  listen_address: 127.0.0.1:9000\n", LoadOptions{Port: 9100}, "127.0.0.1:9100"},
+		{"listen address over host and port", "server:\n  host: 127.0.0.1\n  port: 9000\n", LoadOptions{ListenAddress: "[::1]:9200"}, "[::1]:9200"},
+		{"port over flag listen address", "", LoadOptions{ListenAddress: "[::1]:9200", Port: 9100}, "[::1]:9100"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadConfig(writeConfig(t, tt.file+"dns_servers:\n  - address: 1.1.1.1\ntargets:\n  - fqdn: example.com\n"), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cfg.GetListenAddress(); got != tt.want {
+				t.Errorf("GetListenAddress() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
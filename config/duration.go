@@ -0,0 +1,70 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Duration is a time.Duration read from a Go duration string such as "30s"
+// or "1m30s", or from a bare integer number of seconds as older
+// configurations were written
+type Duration time.Duration
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var value interface{}
+	if err := unmarshal(&value); err != nil {
+		return fmt.Errorf("invalid duration: %w", err)
+	}
+
+	// Numbers other than whole seconds, such as 1.5, are rejected rather
+	// than truncated
+	switch v := value.(type) {
+	case int:
+		*d = Duration(time.Duration(v) * time.Second)
+	case int64:
+		*d = Duration(time.Duration(v) * time.Second)
+	case string:
+		parsed, err := parseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = parsed
+	default:
+		return fmt.Errorf("invalid duration %v: expected a number of seconds or a duration such as \"30s\" or \"1m30s\"", v)
+	}
+	return nil
+}
+
+// parseDuration parses a duration string or a number of seconds
+func parseDuration(s string) (Duration, error) {
+	if seconds, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return Duration(time.Duration(seconds) * time.Second), nil
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q: expected a number of seconds or a duration such as \"30s\" or \"1m30s\"", s)
+	}
+	return Duration(parsed), nil
+}
+
+// String formats the duration like time.Duration
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+// Set implements flag.Value, accepting the same values as the config file
+func (d *Duration) Set(s string) error {
+	parsed, err := parseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler, writing the duration string
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
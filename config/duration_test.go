@@ -0,0 +1,72 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestDurationUnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"30", 30 * time.Second},
+		{"0", 0},
+		{`"30"`, 30 * time.Second},
+		{"30s", 30 * time.Second},
+		{`"30s"`, 30 * time.Second},
+		{"1m30s", 90 * time.Second},
+		{"500ms", 500 * time.Millisecond},
+		{"2h", 2 * time.Hour},
+		{"", 0},
+	}
+	for _, tt := range tests {
+		var settings struct {
+			Interval Duration `yaml:"interval"`
+		}
+		if err := yaml.Unmarshal([]byte("interval: "+tt.value), &settings); err != nil {
+			t.Errorf("interval: %s: %v", tt.value, err)
+			continue
+		}
+		if got := time.Duration(settings.Interval); got != tt.want {
+			t.Errorf("interval: %s = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestDurationUnmarshalYAMLInvalid(t *testing.T) {
+	for _, value := range []string{"thirty", "30x", "1.5", `""`, "[30]", "{seconds: 30}"} {
+		var settings struct {
+			Interval Duration `yaml:"interval"`
+		}
+		err := yaml.Unmarshal([]byte("interval: "+value), &settings)
+		if err == nil {
+			t.Errorf("interval: %s parsed as %v, want an error", value, settings.Interval)
+			continue
+		}
+		if !strings.Contains(err.Error(), "duration") {
+			t.Errorf("interval: %s: error %q does not mention the duration", value, err)
+		}
+	}
+}
+
+func TestDurationMarshalYAML(t *testing.T) {
+	// Durations are written as strings, which read back the same
+	data, err := yaml.Marshal(map[string]Duration{"interval": Duration(90 * time.Second)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(data)); got != "interval: 1m30s" {
+		t.Errorf("marshaled %q, want %q", got, "interval: 1m30s")
+	}
+	var back map[string]Duration
+	if err := yaml.Unmarshal(data, &back); err != nil {
+		t.Fatal(err)
+	}
+	if back["interval"] != Duration(90*time.Second) {
+		t.Errorf("read back %v, want 1m30s", back["interval"])
+	}
+}
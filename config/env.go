@@ -0,0 +1,132 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Environment variables configuring the exporter without, or on top of, a
+// configuration file
+const (
+	// Semicolon separated targets, e.g. "example.com:A,AAAA;api.example.com",
+	// targets without record types use the defaults
+	EnvTargets = "DNS_EXPORTER_TARGETS"
+	// Comma separated servers as name=address or address, e.g.
+	// "cloudflare=1.1.1.1,google=8.8.8.8"
+	EnvServers = "DNS_EXPORTER_SERVERS"
+	// Duration or number of seconds
+	EnvInterval = "DNS_EXPORTER_INTERVAL"
+	// Duration or number of seconds
+	EnvTimeout = "DNS_EXPORTER_TIMEOUT"
+)
+
+var envSettings = []string{EnvTargets, EnvServers, EnvInterval, EnvTimeout}
+
+// envConfigured reports whether any of the configuration environment
+// variables is set
+func envConfigured() bool {
+	for _, name := range envSettings {
+		if _, ok := os.LookupEnv(name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// writeEnv writes the configuration environment variables that are set to
+// w, so they are part of the configuration hash
+func writeEnv(w io.Writer) {
+	for _, name := range envSettings {
+		if value, ok := os.LookupEnv(name); ok {
+			fmt.Fprintf(w, "%s=%s\n", name, value)
+		}
+	}
+}
+
+// applyEnv overrides the settings given by environment variables. Targets
+// and servers replace the lists of the file as a whole.
+func (c *Config) applyEnv() error {
+	if value, ok := os.LookupEnv(EnvTargets); ok {
+		targets, err := parseEnvTargets(value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", EnvTargets, err)
+		}
+		c.Targets = targets
+	}
+	if value, ok := os.LookupEnv(EnvServers); ok {
+		c.DNSServers = parseEnvServers(value)
+	}
+	if value, ok := os.LookupEnv(EnvInterval); ok {
+		interval, err := parseDuration(unquote(value))
+		if err != nil {
+			return fmt.Errorf("%s: %w", EnvInterval, err)
+		}
+		c.Monitoring.Interval = interval
+	}
+	if value, ok := os.LookupEnv(EnvTimeout); ok {
+		timeout, err := parseDuration(unquote(value))
+		if err != nil {
+			return fmt.Errorf("%s: %w", EnvTimeout, err)
+		}
+		c.Monitoring.Timeout = timeout
+	}
+	return nil
+}
+
+// parseEnvTargets parses "fqdn:TYPE,TYPE;fqdn". Empty entries and record
+// types are skipped, so trailing separators do no harm.
+func parseEnvTargets(value string) ([]Target, error) {
+	var targets []Target
+	for _, entry := range splitList(value, ";") {
+		fqdn, types, _ := strings.Cut(entry, ":")
+		fqdn = strings.TrimSpace(fqdn)
+		recordTypes := splitList(types, ",")
+		if fqdn == "" {
+			return nil, fmt.Errorf("invalid target %q: expected fqdn[:TYPE,TYPE...]", entry)
+		}
+		for i, recordType := range recordTypes {
+			recordTypes[i] = strings.ToUpper(recordType)
+		}
+		targets = append(targets, Target{FQDN: fqdn, RecordTypes: recordTypes})
+	}
+	return targets, nil
+}
+
+// parseEnvServers parses "name=address,address". An entry whose part
+// before "=" looks like an address or URL has no name, e.g. a DoH URL with
+// a query string.
+func parseEnvServers(value string) []DNSServer {
+	var servers []DNSServer
+	for _, entry := range splitList(value, ",") {
+		server := DNSServer{Address: entry}
+		if name, address, ok := strings.Cut(entry, "="); ok && !strings.ContainsAny(name, ":/") {
+			server = DNSServer{Name: strings.TrimSpace(name), Address: strings.TrimSpace(address)}
+		}
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+// splitList splits value at sep, trims spaces and surrounding quotes from
+// the items and drops empty ones. The value as a whole may be quoted as
+// well, as env files passed to containers keep quotes literally.
+func splitList(value, sep string) []string {
+	var items []string
+	for _, item := range strings.Split(unquote(value), sep) {
+		if item = unquote(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// unquote trims spaces and one pair of matching surrounding quotes
+func unquote(value string) string {
+	value = strings.TrimSpace(value)
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		value = strings.TrimSpace(value[1 : len(value)-1])
+	}
+	return value
+}
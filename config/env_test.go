@@ -0,0 +1,112 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseEnvTargets(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []Target
+	}{
+		{"example.com", []Target{{FQDN: "example.com"}}},
+		{"example.com:A,aaaa;api.example.com", []Target{
+			{FQDN: "example.com", RecordTypes: []string{"A", "AAAA"}},
+			{FQDN: "api.example.com"},
+		}},
+		{`"example.com:A;api.example.com"`, []Target{
+			{FQDN: "example.com", RecordTypes: []string{"A"}},
+			{FQDN: "api.example.com"},
+		}},
+		{`'example.com:MX' ; "api.example.com"`, []Target{
+			{FQDN: "example.com", RecordTypes: []string{"MX"}},
+			{FQDN: "api.example.com"},
+		}},
+		{" example.com : A , AAAA ;; api.example.com; ", []Target{
+			{FQDN: "example.com", RecordTypes: []string{"A", "AAAA"}},
+			{FQDN: "api.example.com"},
+		}},
+		{"example.com:A,,AAAA,", []Target{{FQDN: "example.com", RecordTypes: []string{"A", "AAAA"}}}},
+		{"example.com:", []Target{{FQDN: "example.com"}}},
+		{"", nil},
+		{`""`, nil},
+		{";;", nil},
+	}
+	for _, tt := range tests {
+		got, err := parseEnvTargets(tt.value)
+		if err != nil {
+			t.Errorf("parseEnvTargets(%q): %v", tt.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseEnvTargets(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseEnvTargetsInvalid(t *testing.T) {
+	for _, value := range []string{":A", "example.com;:AAAA", ` :A`} {
+		if targets, err := parseEnvTargets(value); err == nil {
+			t.Errorf("parseEnvTargets(%q) = %+v, want an error", value, targets)
+		}
+	}
+}
+
+func TestParseEnvServers(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []DNSServer
+	}{
+		{"1.1.1.1", []DNSServer{{Address: "1.1.1.1"}}},
+		{"cloudflare=1.1.1.1,google=8.8.8.8", []DNSServer{
+			{Name: "cloudflare", Address: "1.1.1.1"},
+			{Name: "google", Address: "8.8.8.8"},
+		}},
+		{`"cloudflare=1.1.1.1, 8.8.8.8"`, []DNSServer{
+			{Name: "cloudflare", Address: "1.1.1.1"},
+			{Address: "8.8.8.8"},
+		}},
+		{`'cloudflare = 1.1.1.1',"google=8.8.8.8"`, []DNSServer{
+			{Name: "cloudflare", Address: "1.1.1.1"},
+			{Name: "google", Address: "8.8.8.8"},
+		}},
+		{",,1.1.1.1,,", []DNSServer{{Address: "1.1.1.1"}}},
+		{"2606:4700:4700::1111", []DNSServer{{Address: "2606:4700:4700::1111"}}},
+		{"https://dns.example/dns-query?ct=application/dns-message", []DNSServer{
+			{Address: "https://dns.example/dns-query?ct=application/dns-message"},
+		}},
+		{"example=https://dns.example/dns-query?ct=application/dns-message", []DNSServer{
+			{Name: "example", Address: "https://dns.example/dns-query?ct=application/dns-message"},
+		}},
+		{"", nil},
+		{`''`, nil},
+	}
+	for _, tt := range tests {
+		if got := parseEnvServers(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseEnvServers(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestUnquote(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"30s", "30s"},
+		{" 30s ", "30s"},
+		{`"30s"`, "30s"},
+		{`'30s'`, "30s"},
+		{`" 30s "`, "30s"},
+		{`"30s'`, `"30s'`},
+		{`"`, `"`},
+		{`""`, ""},
+		{`""30s""`, `"30s"`},
+	}
+	for _, tt := range tests {
+		if got := unquote(tt.value); got != tt.want {
+			t.Errorf("unquote(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
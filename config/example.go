@@ -0,0 +1,73 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// exampleTarget and exampleServer are written commented out after the
+// default configuration, as a template of every setting
+var (
+	exampleEnabled = true
+	exampleTarget  = Target{
+		FQDN:        "example.com",
+		RecordTypes: []string{"A", "AAAA"},
+		Enabled:     &exampleEnabled,
+	}
+	exampleServer = DNSServer{
+		Name:    "google",
+		Address: "8.8.8.8",
+	}
+)
+
+// DefaultConfig returns the configuration in effect for an empty file, with
+// the defaults filled in
+func DefaultConfig() *Config {
+	c := &Config{}
+	c.setDefaults()
+	c.Defaults.RecordTypes = slices.Clone(defaultRecordTypes)
+	ndots := defaultNDots
+	c.Monitoring.NDots = &ndots
+	return c
+}
+
+// WriteDefault writes the default configuration with every setting, followed
+// by an example target and DNS server commented out. Loading it results in
+// the same configuration as DefaultConfig.
+func WriteDefault(w io.Writer) error {
+	data, err := yaml.Marshal(DefaultConfig())
+	if err != nil {
+		return err
+	}
+	target, err := yaml.Marshal([]Target{exampleTarget})
+	if err != nil {
+		return err
+	}
+	server, err := yaml.Marshal([]DNSServer{exampleServer})
+	if err != nil {
+		return err
+	}
+
+	var b strings.Builder
+	b.WriteString("# DNS Trace Exporter default configuration, every setting at its default\n")
+	b.Write(data)
+	b.WriteString("\n# Example DNS server, to be added to dns_servers:\n")
+	b.WriteString(commentOut(server))
+	b.WriteString("\n# Example target, to be added to targets:\n")
+	b.WriteString(commentOut(target))
+	_, err = fmt.Fprint(w, b.String())
+	return err
+}
+
+// commentOut prefixes every line of data with "# "
+func commentOut(data []byte) string {
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = "# " + line
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
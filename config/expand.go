@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// envReference matches ${VAR} and ${VAR:-default}
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// expandEnv replaces the environment variable references in data with their
+// values. Like in the shell the default of ${VAR:-default} is used when VAR
+// is unset or empty. Any other dollar sign is left as is. References to
+// undefined variables without a default fail, listing every such variable.
+func expandEnv(data []byte) ([]byte, error) {
+	var undefined []string
+	expanded := envReference.ReplaceAllFunc(data, func(ref []byte) []byte {
+		match := envReference.FindSubmatch(ref)
+		name := string(match[1])
+		hasDefault := strings.Contains(string(ref), ":-")
+
+		if value, ok := os.LookupEnv(name); ok && (value != "" || !hasDefault) {
+			return []byte(value)
+		}
+		if hasDefault {
+			return match[2]
+		}
+		if !slices.Contains(undefined, name) {
+			undefined = append(undefined, name)
+		}
+		return ref
+	})
+
+	if len(undefined) > 0 {
+		slices.Sort(undefined)
+		return nil, fmt.Errorf("undefined environment variables without default: %s", strings.Join(undefined, ", "))
+	}
+	return expanded, nil
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// FileMode is an os.FileMode read from an octal string such as "0660", or
+// from a YAML octal number (0660 without quotes)
+type FileMode os.FileMode
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (m *FileMode) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil {
+		mode, err := strconv.ParseUint(s, 8, 32)
+		if err != nil {
+			return fmt.Errorf("invalid file mode %q: expected octal permissions such as \"0660\"", s)
+		}
+		*m = FileMode(mode)
+		return nil
+	}
+
+	var mode uint32
+	if err := unmarshal(&mode); err != nil {
+		return fmt.Errorf("invalid file mode: %w", err)
+	}
+	*m = FileMode(mode)
+	return nil
+}
+
+// String formats the mode in octal
+func (m FileMode) String() string {
+	return fmt.Sprintf("%#o", uint32(m))
+}
+
+// MarshalYAML implements yaml.Marshaler, writing the octal string
+func (m FileMode) MarshalYAML() (interface{}, error) {
+	return m.String(), nil
+}
@@ -0,0 +1,130 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Formats of configuration files
+const (
+	formatYAML = "yaml"
+	formatJSON = "json"
+	formatTOML = "toml"
+)
+
+// fileFormat returns the format of a configuration file by its extension
+// or, without a known extension, by its content, JSON files being objects
+func fileFormat(filename string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return formatJSON
+	case ".yaml", ".yml":
+		return formatYAML
+	case ".toml":
+		return formatTOML
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		return formatJSON
+	}
+	return formatYAML
+}
+
+// unmarshalConfig parses data in format into config. JSON and TOML are
+// converted to YAML first, so all formats share the schema, the defaults
+// and the parsing of types such as Duration. In strict mode, unknown and
+// duplicate settings are errors.
+func unmarshalConfig(format string, data []byte, config *Config, strict bool) error {
+	var err error
+	switch format {
+	case formatJSON:
+		data, err = jsonToYAML(data)
+	case formatTOML:
+		data, err = tomlToYAML(data)
+	}
+	if err != nil {
+		return err
+	}
+
+	unmarshal := yaml.Unmarshal
+	if strict {
+		unmarshal = yaml.UnmarshalStrict
+	}
+	err = unmarshal(data, config)
+	// The lines of converted files are those of the YAML, not the file
+	var typeErr *yaml.TypeError
+	if format != formatYAML && errors.As(err, &typeErr) {
+		for i, message := range typeErr.Errors {
+			typeErr.Errors[i] = yamlLinePrefix.ReplaceAllString(message, "")
+		}
+	}
+	return err
+}
+
+// yamlLinePrefix matches the line the YAML parser prefixes errors with
+var yamlLinePrefix = regexp.MustCompile(`^line \d+: `)
+
+// jsonToYAML converts a JSON document to YAML. Syntax errors are reported
+// with their line. The YAML parser is not used on JSON directly, as it does
+// not understand all of JSON's string escapes.
+func jsonToYAML(data []byte) ([]byte, error) {
+	var value interface{}
+	err := json.Unmarshal(data, &value)
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		line := 1 + bytes.Count(data[:syntaxErr.Offset], []byte("\n"))
+		return nil, fmt.Errorf("line %d: %w", line, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(numericKeys(value))
+}
+
+// tomlToYAML converts a TOML document to YAML
+func tomlToYAML(data []byte) ([]byte, error) {
+	var value map[string]interface{}
+	if _, err := toml.Decode(string(data), &value); err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(numericKeys(value))
+}
+
+// numericKeys turns the keys of the maps within value that are numbers into
+// numbers. JSON and TOML keys are always strings, while YAML keys such as
+// the quantiles of latency_quantiles are numbers.
+func numericKeys(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		converted := make(map[interface{}]interface{}, len(value))
+		for key, item := range value {
+			if number, err := strconv.ParseFloat(key, 64); err == nil {
+				converted[number] = numericKeys(item)
+			} else {
+				converted[key] = numericKeys(item)
+			}
+		}
+		return converted
+	case []map[string]interface{}:
+		converted := make([]interface{}, len(value))
+		for i, item := range value {
+			converted[i] = numericKeys(item)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(value))
+		for i, item := range value {
+			converted[i] = numericKeys(item)
+		}
+		return converted
+	}
+	return value
+}
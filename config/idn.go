@@ -0,0 +1,43 @@
+package config
+
+import (
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// Values of MonitorConfig.IDNLabel
+const (
+	IDNLabelUnicode = "unicode"
+	IDNLabelASCII   = "ascii"
+)
+
+// idnNames returns the A-label (punycode) form of name, which is queried, and
+// its U-label (Unicode) form. Names without non-ASCII characters or xn--
+// labels are returned unchanged, so that names such as _dmarc.example.com
+// that are not valid host names can still be monitored.
+func idnNames(name string) (ascii, unicode string, err error) {
+	if !isInternationalized(name) {
+		return name, name, nil
+	}
+	ascii, err = idna.Lookup.ToASCII(name)
+	if err != nil {
+		return "", "", err
+	}
+	unicode, err = idna.Lookup.ToUnicode(ascii)
+	if err != nil {
+		return "", "", err
+	}
+	return ascii, unicode, nil
+}
+
+// isInternationalized reports whether name contains non-ASCII characters or
+// labels in punycode
+func isInternationalized(name string) bool {
+	for _, r := range name {
+		if r >= 0x80 {
+			return true
+		}
+	}
+	return strings.HasPrefix(name, "xn--") || strings.Contains(name, ".xn--")
+}
@@ -0,0 +1,56 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Jitter is the maximum random delay of probes, either a duration such as
+// "5s" or a percentage of the interval such as "10%"
+type Jitter struct {
+	Duration Duration
+	Percent  float64
+}
+
+// UnmarshalYAML implements yaml.Unmarshaler
+func (j *Jitter) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err == nil && strings.HasSuffix(s, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(s, "%")), 64)
+		if err != nil {
+			return fmt.Errorf("invalid jitter %q: expected a duration such as \"5s\" or a percentage such as \"10%%\"", s)
+		}
+		*j = Jitter{Percent: percent}
+		return nil
+	}
+
+	var d Duration
+	if err := d.UnmarshalYAML(unmarshal); err != nil {
+		return err
+	}
+	*j = Jitter{Duration: d}
+	return nil
+}
+
+// MarshalYAML implements yaml.Marshaler
+func (j Jitter) MarshalYAML() (interface{}, error) {
+	return j.String(), nil
+}
+
+// String formats the jitter as it is configured
+func (j Jitter) String() string {
+	if j.Percent != 0 {
+		return strconv.FormatFloat(j.Percent, 'f', -1, 64) + "%"
+	}
+	return j.Duration.String()
+}
+
+// Of returns the maximum delay of probes at interval
+func (j Jitter) Of(interval time.Duration) time.Duration {
+	if j.Percent != 0 {
+		return time.Duration(float64(interval) * j.Percent / 100)
+	}
+	return time.Duration(j.Duration)
+}
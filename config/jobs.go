@@ -0,0 +1,134 @@
+package config
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// JobLabel is added to every metric of the targets of a job, holding the
+// job's name. Top-level targets have it empty. It is not named job, which
+// Prometheus sets to the scrape job and would rename to exported_job.
+const JobLabel = "probe_job"
+
+// Job is a monitoring profile with targets and DNS servers of its own, e.g.
+// internal names queried via the internal resolvers more often than the
+// top-level targets. Its interval, timeout and record types apply to its
+// targets and servers that do not set their own, its zone to its targets.
+// As the metrics of a target are told apart by its fqdn, a name can only be
+// monitored by one job, or by the top-level targets.
+type Job struct {
+	Name        string      `yaml:"name"`
+	Targets     []Target    `yaml:"targets"`
+	DNSServers  []DNSServer `yaml:"dns_servers"`
+	Interval    Duration    `yaml:"interval"`
+	Timeout     Duration    `yaml:"timeout"`
+	RecordTypes []string    `yaml:"record_types"`
+	// Zone appended to the names of the job's targets that are not fully
+	// qualified, instead of defaults.zone
+	Zone string `yaml:"zone"`
+}
+
+// addJobs appends the targets and servers of the jobs to the top-level ones
+// with the job's settings filled in. They keep the name of their job, as
+// targets are only queried against the servers of the same job.
+func (c *Config) addJobs() {
+	for i, job := range c.Jobs {
+		for j, target := range job.Targets {
+			target.Job = job.Name
+			target.path = fmt.Sprintf("jobs[%d].targets[%d]", i, j)
+			target.zone = job.Zone
+			if len(target.RecordTypes) == 0 {
+				target.RecordTypes = slices.Clone(job.RecordTypes)
+			}
+			if target.Interval == 0 {
+				target.Interval = job.Interval
+			}
+			labels := maps.Clone(target.Labels)
+			if labels == nil {
+				labels = make(map[string]string)
+			}
+			labels[JobLabel] = job.Name
+			target.Labels = labels
+			c.Targets = append(c.Targets, target)
+		}
+		for j, server := range job.DNSServers {
+			server.Job = job.Name
+			server.path = fmt.Sprintf("jobs[%d].dns_servers[%d]", i, j)
+			if server.Timeout == 0 {
+				server.Timeout = job.Timeout
+			}
+			c.DNSServers = append(c.DNSServers, server)
+		}
+	}
+}
+
+// jobDescription names the job of a target in messages
+func jobDescription(job string) string {
+	if job == "" {
+		return "the top-level targets"
+	}
+	return fmt.Sprintf("job %q", job)
+}
+
+// validateJobs checks the settings of the jobs themselves, their targets
+// and servers are checked with the top-level ones
+func (c *Config) validateJobs() []error {
+	var errs []error
+	fail := func(field string, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	names := make(map[string]bool)
+	for i, job := range c.Jobs {
+		field := fmt.Sprintf("jobs[%d]", i)
+		if job.Name == "" {
+			fail(field+".name", "is required")
+		} else if names[job.Name] {
+			fail(field+".name", "duplicate job name %q", job.Name)
+		}
+		names[job.Name] = true
+
+		if len(job.Targets) == 0 {
+			fail(field+".targets", "must not be empty")
+		}
+		if len(job.DNSServers) == 0 {
+			fail(field+".dns_servers", "must not be empty")
+		}
+		for _, recordType := range job.RecordTypes {
+			if !slices.Contains(recordTypes, recordType) {
+				fail(field+".record_types", "unknown record type %q", recordType)
+			}
+		}
+
+		if err := checkZone(job.Zone); err != nil {
+			fail(field+".zone", "%v", err)
+		}
+
+		interval := cmp.Or(job.Interval, c.Monitoring.Interval)
+		timeout := cmp.Or(job.Timeout, c.Monitoring.Timeout)
+		if job.Interval < 0 {
+			fail(field+".interval", "must not be negative")
+		}
+		if job.Timeout < 0 {
+			fail(field+".timeout", "must not be negative")
+		} else if timeout > interval {
+			fail(field+".timeout", "%v is longer than the interval of %v", timeout, interval)
+		}
+		for j, target := range job.Targets {
+			if _, ok := target.Labels[JobLabel]; ok {
+				fail(fmt.Sprintf("%s.targets[%d].labels", field, j), "label %q is set by the job", JobLabel)
+			}
+			if target.Interval > 0 && target.Interval < timeout {
+				fail(fmt.Sprintf("%s.targets[%d].interval", field, j), "%v is shorter than the timeout of %v", target.Interval, timeout)
+			}
+		}
+		for j, server := range job.DNSServers {
+			if server.Timeout > interval {
+				fail(fmt.Sprintf("%s.dns_servers[%d].timeout", field, j), "%v is longer than the interval of %v", server.Timeout, interval)
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MaintenanceWindow is a recurring period in which a target is expected to
+// misbehave, e.g. Sundays from 02:00 to 04:00 in the vendor's time zone. A
+// window ending at or before its start ends on the following day.
+type MaintenanceWindow struct {
+	// Days the window starts on, e.g. ["sat", "sun"] (default every day)
+	Weekdays []string `yaml:"weekdays"`
+	// Start and end as HH:MM
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+	// IANA time zone of start and end (default UTC)
+	Timezone string `yaml:"timezone"`
+	// Skip the probes of the target instead of only flagging them
+	SuppressProbes bool `yaml:"suppress_probes"`
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday,
+	"wed": time.Wednesday, "thu": time.Thursday, "fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseWeekday accepts short and full English day names in any case
+func parseWeekday(name string) (time.Weekday, bool) {
+	name = strings.ToLower(name)
+	if len(name) < 3 {
+		return 0, false
+	}
+	day, ok := weekdays[name[:3]]
+	if !ok || !strings.HasPrefix(strings.ToLower(day.String()), name) {
+		return 0, false
+	}
+	return day, true
+}
+
+// parseClock returns the minutes after midnight of HH:MM
+func parseClock(clock string) (int, error) {
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", clock)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// locations caches the time zones of the windows, which are read from the
+// time zone database on every LoadLocation
+var locations sync.Map
+
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// validate checks the window's days, times and time zone
+func (w MaintenanceWindow) validate() error {
+	for _, name := range w.Weekdays {
+		if _, ok := parseWeekday(name); !ok {
+			return fmt.Errorf("invalid weekday %q", name)
+		}
+	}
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return fmt.Errorf("start: %w", err)
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return fmt.Errorf("end: %w", err)
+	}
+	if start == end {
+		return fmt.Errorf("start and end are both %s", w.Start)
+	}
+	if _, err := loadLocation(w.Timezone); err != nil {
+		return fmt.Errorf("timezone: %w", err)
+	}
+	return nil
+}
+
+// Contains reports whether t is within the window. Windows passing
+// midnight belong to the day they start on.
+func (w MaintenanceWindow) Contains(t time.Time) bool {
+	loc, err := loadLocation(w.Timezone)
+	if err != nil {
+		return false
+	}
+	start, _ := parseClock(w.Start)
+	end, _ := parseClock(w.End)
+
+	t = t.In(loc)
+	minute := t.Hour()*60 + t.Minute()
+	if start < end {
+		return w.on(t.Weekday()) && minute >= start && minute < end
+	}
+	yesterday := (t.Weekday() + 6) % 7
+	return (w.on(t.Weekday()) && minute >= start) || (w.on(yesterday) && minute < end)
+}
+
+// on reports whether the window starts on day
+func (w MaintenanceWindow) on(day time.Weekday) bool {
+	if len(w.Weekdays) == 0 {
+		return true
+	}
+	for _, name := range w.Weekdays {
+		if d, _ := parseWeekday(name); d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// InMaintenance reports whether target is within one of its own or the
+// global maintenance windows at t, and whether one of those windows
+// suppresses its probes
+func (c *Config) InMaintenance(target Target, t time.Time) (inMaintenance, suppress bool) {
+	for _, windows := range [][]MaintenanceWindow{c.Monitoring.MaintenanceWindows, target.MaintenanceWindows} {
+		for _, window := range windows {
+			if window.Contains(t) {
+				inMaintenance = true
+				suppress = suppress || window.SuppressProbes
+			}
+		}
+	}
+	return inMaintenance, suppress
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWindowContains(t *testing.T) {
+	// 2024-06-01 is a Saturday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, time.June, day, hour, minute, 0, 0, time.UTC)
+	}
+	sunday := MaintenanceWindow{Weekdays: []string{"sun"}, Start: "02:00", End: "04:00"}
+	overnight := MaintenanceWindow{Weekdays: []string{"Saturday"}, Start: "23:00", End: "01:00"}
+	daily := MaintenanceWindow{Start: "23:30", End: "00:30"}
+	newYork := MaintenanceWindow{Weekdays: []string{"sun"}, Start: "02:00", End: "04:00", Timezone: "America/New_York"}
+	tests := []struct {
+		name   string
+		window MaintenanceWindow
+		t      time.Time
+		want   bool
+	}{
+		{"before start", sunday, at(2, 1, 59), false},
+		{"at start", sunday, at(2, 2, 0), true},
+		{"within", sunday, at(2, 3, 59), true},
+		{"at end", sunday, at(2, 4, 0), false},
+		{"other day", sunday, at(1, 3, 0), false},
+		{"next week", sunday, at(9, 2, 0), true},
+		{"overnight before start", overnight, at(1, 22, 59), false},
+		{"overnight at start", overnight, at(1, 23, 0), true},
+		{"overnight after midnight", overnight, at(2, 0, 59), true},
+		{"overnight at end", overnight, at(2, 1, 0), false},
+		{"overnight starting the day before", overnight, at(1, 0, 30), false},
+		{"overnight on the start day only", overnight, at(2, 23, 30), false},
+		{"every day before midnight", daily, at(3, 23, 45), true},
+		{"every day after midnight", daily, at(4, 0, 15), true},
+		{"every day at end", daily, at(4, 0, 30), false},
+		{"time zone at start", newYork, at(2, 6, 0), true},
+		{"time zone at end", newYork, at(2, 8, 0), false},
+		{"time zone in utc", newYork, at(2, 2, 0), false},
+		{"time zone of t ignored", sunday, time.Date(2024, time.June, 1, 22, 0, 0, 0, time.FixedZone("UTC-4", -4*3600)), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.window.validate(); err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.window.Contains(tt.t); got != tt.want {
+				t.Errorf("Contains(%v) = %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaintenanceWindowValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		window MaintenanceWindow
+		valid  bool
+	}{
+		{"full day names", MaintenanceWindow{Weekdays: []string{"Monday", "TUE", "wedn"}, Start: "00:00", End: "23:59"}, true},
+		{"ending at midnight", MaintenanceWindow{Start: "22:00", End: "00:00"}, true},
+		{"unknown day", MaintenanceWindow{Weekdays: []string{"mo"}, Start: "02:00", End: "04:00"}, false},
+		{"misspelled day", MaintenanceWindow{Weekdays: []string{"sunnday"}, Start: "02:00", End: "04:00"}, false},
+		{"invalid start", MaintenanceWindow{Start: "24:00", End: "04:00"}, false},
+		{"invalid end", MaintenanceWindow{Start: "02:00", End: "4pm"}, false},
+		{"empty", MaintenanceWindow{Start: "02:00", End: "02:00"}, false},
+		{"unknown time zone", MaintenanceWindow{Start: "02:00", End: "04:00", Timezone: "Mars/Olympus_Mons"}, false},
+	}
+	for _, tt := range tests {
+		err := tt.window.validate()
+		if tt.valid && err != nil {
+			t.Errorf("%s: validate() = %v, want nil", tt.name, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("%s: validate() = nil, want an error", tt.name)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"strings"
+)
+
+// resolvConfPath is the resolver configuration read for
+// dns_servers_from_resolvconf
+var resolvConfPath = "/etc/resolv.conf"
+
+// resolvConfServers returns the nameserver addresses listed in the resolver
+// configuration at path. A missing file, as on systems without one, yields
+// no servers rather than an error. Scoped IPv6 addresses (fe80::1%eth0) are
+// skipped since server addresses cannot carry a zone.
+func resolvConfServers(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var addresses []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		if net.ParseIP(fields[1]) != nil {
+			addresses = append(addresses, fields[1])
+		}
+	}
+	return addresses, scanner.Err()
+}
+
+// addResolvConfServers appends the nameservers of the resolver configuration
+// to the configured DNS servers, named resolvconf-0, resolvconf-1, ... by
+// their position in the file. Addresses that are already configured are
+// left out.
+func (c *Config) addResolvConfServers() error {
+	addresses, err := resolvConfServers(resolvConfPath)
+	if err != nil {
+		return fmt.Errorf("dns_servers_from_resolvconf: %w", err)
+	}
+
+	for i, address := range addresses {
+		if c.hasServerAddress(address) {
+			continue
+		}
+		c.DNSServers = append(c.DNSServers, DNSServer{
+			Name:    fmt.Sprintf("resolvconf-%d", i),
+			Address: address,
+		})
+	}
+	return nil
+}
+
+// hasServerAddress reports whether a DNS server with address is configured,
+// with or without the default port
+func (c *Config) hasServerAddress(address string) bool {
+	for _, server := range c.DNSServers {
+		if server.Address == address || server.Address == net.JoinHostPort(address, "53") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"net"
+	"strings"
+)
+
+// defaultNDots is the ndots of resolv.conf when monitoring.ndots is not set
+const defaultNDots = 1
+
+// maxNDots is the highest ndots resolv.conf accepts
+const maxNDots = 15
+
+// searchCandidates returns the names queried for the relative name in order,
+// the way a stub resolver applies a search list: names with at least ndots
+// dots are tried as given first, others after the search domains.
+func searchCandidates(name string, domains []string, ndots int) []string {
+	candidates := make([]string, 0, len(domains)+1)
+	asGiven := strings.Count(name, ".") >= ndots
+	if asGiven {
+		candidates = append(candidates, name)
+	}
+	for _, domain := range domains {
+		candidates = append(candidates, name+"."+domain)
+	}
+	if !asGiven {
+		candidates = append(candidates, name)
+	}
+	return candidates
+}
+
+// SearchNames returns the names queried in order for the target, nil when
+// only its own name is. Names written with a trailing dot are absolute and,
+// like IP addresses of PTR targets and the root zone, bypass the search list.
+func (c *Config) SearchNames(target Target) []string {
+	if len(c.Monitoring.SearchDomains) == 0 || target.absolute || target.FQDN == "." || net.ParseIP(target.FQDN) != nil {
+		return nil
+	}
+	return searchCandidates(target.QueryName(), c.Monitoring.SearchDomains, c.GetNDots())
+}
+
+// GetNDots returns the number of dots from which relative names are tried
+// as given before the search domains
+func (c *Config) GetNDots() int {
+	if c.Monitoring.NDots != nil {
+		return *c.Monitoring.NDots
+	}
+	return defaultNDots
+}
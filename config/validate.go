@@ -0,0 +1,397 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+)
+
+// recordTypes lists the record types targets can be queried for
+var recordTypes = []string{"A", "AAAA", "MX", "TXT", "NS", "SOA", "PTR", "SRV", "HTTPS", "SVCB", "DS", "DNSKEY"}
+
+// Validate checks the configuration and returns all problems found at once,
+// each prefixed with the setting it concerns, e.g. targets[2].record_types.
+// Defaults are expected to be filled in already.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(field string, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if c.Server.ListenAddress != "" {
+		if c.Server.Host != "" || c.Server.Port != 0 {
+			fail("server.listen_address", "cannot be combined with host and port")
+		} else if err := checkListenAddress(c.Server.ListenAddress); err != nil {
+			fail("server.listen_address", "%v", err)
+		}
+	} else if (c.Server.Port != 0 || c.Server.SocketPath == "") && (c.Server.Port < 1 || c.Server.Port > 65535) {
+		fail("server.port", "%d is not a valid port", c.Server.Port)
+	}
+	if c.Server.SocketMode&^FileMode(os.ModePerm) != 0 {
+		fail("server.socket_mode", "%v is not a valid file mode", c.Server.SocketMode)
+	}
+	if c.Server.SocketMode != 0 && c.Server.SocketPath == "" {
+		fail("server.socket_mode", "requires socket_path")
+	}
+	if c.Monitoring.Interval <= 0 {
+		fail("monitoring.interval", "must be positive")
+	}
+	if c.Monitoring.Timeout <= 0 {
+		fail("monitoring.timeout", "must be positive")
+	} else if c.Monitoring.Timeout > c.Monitoring.Interval {
+		fail("monitoring.timeout", "%v is longer than the interval of %v", c.Monitoring.Timeout, c.Monitoring.Interval)
+	}
+	if jitter := c.Monitoring.Jitter; jitter.Percent < 0 || jitter.Percent >= 100 || jitter.Duration < 0 {
+		fail("monitoring.jitter", "%v is not a valid jitter, it must not be negative and less than 100%%", jitter)
+	} else if jitter.Duration >= c.Monitoring.Interval && c.Monitoring.Interval > 0 {
+		fail("monitoring.jitter", "%v is not shorter than the interval of %v", jitter, c.Monitoring.Interval)
+	}
+	if c.Monitoring.MaxExportedIPs < 0 {
+		fail("monitoring.max_exported_ips", "must not be negative")
+	}
+	if c.Monitoring.ResponseTimeEWMAAlpha < 0 || c.Monitoring.ResponseTimeEWMAAlpha > 1 {
+		fail("monitoring.response_time_ewma_alpha", "must be between 0 and 1")
+	}
+	if c.Monitoring.MetricStalenessTTL < 0 {
+		fail("monitoring.metric_staleness_ttl", "must not be negative")
+	} else if interval, field := c.longestInterval(); c.Monitoring.MetricStalenessTTL > 0 && c.Monitoring.MetricStalenessTTL <= interval {
+		// The series of a target are only updated by its probes
+		fail("monitoring.metric_staleness_ttl", "%v is not longer than %s of %v, series would be deleted between probes", c.Monitoring.MetricStalenessTTL, field, interval)
+	}
+	if c.Monitoring.AvailabilityWindow < 0 {
+		fail("monitoring.availability_window", "must not be negative")
+	}
+	if c.Monitoring.Retries < 0 {
+		fail("monitoring.retries", "must not be negative")
+	}
+	if c.Monitoring.RetryBackoff < 0 {
+		fail("monitoring.retry_backoff", "must not be negative")
+	}
+	for _, class := range c.Monitoring.RetryOn {
+		if !slices.Contains(retryErrorClasses, class) {
+			fail("monitoring.retry_on", "unknown error class %q, supported are %s", class, strings.Join(retryErrorClasses, ", "))
+		}
+	}
+	for _, domain := range c.Monitoring.SearchDomains {
+		if domain == "" || domain == "." {
+			fail("monitoring.search_domains", "must not contain empty domains")
+		}
+	}
+	if ndots := c.Monitoring.NDots; ndots != nil && (*ndots < 0 || *ndots > maxNDots) {
+		fail("monitoring.ndots", "must be between 0 and %d", maxNDots)
+	}
+	if c.Monitoring.QueriesPerProbe < 0 {
+		fail("monitoring.queries_per_probe", "must not be negative")
+	}
+	for i, window := range c.Monitoring.MaintenanceWindows {
+		if err := window.validate(); err != nil {
+			fail(fmt.Sprintf("monitoring.maintenance_windows[%d]", i), "%v", err)
+		}
+	}
+	for i, bucket := range c.Monitoring.LatencyBuckets {
+		if bucket <= 0 || (i > 0 && bucket <= c.Monitoring.LatencyBuckets[i-1]) {
+			fail("monitoring.latency_buckets", "must be positive and increasing")
+			break
+		}
+	}
+	for quantile, maxError := range c.Monitoring.LatencyQuantiles {
+		if quantile <= 0 || quantile >= 1 || maxError <= 0 || maxError >= 1 {
+			fail("monitoring.latency_quantiles", "%v: quantiles and errors must be between 0 and 1", quantile)
+		}
+	}
+	switch c.Monitoring.DNSServerLabel {
+	case "", DNSServerLabelAddress:
+	case DNSServerLabelName:
+		// Names become label values and must tell the servers apart
+		names := make(map[string]bool)
+		for i, server := range c.DNSServers {
+			if server.Name == "" || names[server.Name] {
+				fail(server.field(i)+".name", "dns_server_label %q requires unique names, %q is empty or duplicate", DNSServerLabelName, server.Name)
+			}
+			names[server.Name] = true
+		}
+	default:
+		fail("monitoring.dns_server_label", "invalid value %q", c.Monitoring.DNSServerLabel)
+	}
+	switch c.Monitoring.IDNLabel {
+	case IDNLabelUnicode, IDNLabelASCII:
+	default:
+		fail("monitoring.idn_label", "invalid value %q", c.Monitoring.IDNLabel)
+	}
+	switch c.Monitoring.OverrunPolicy {
+	case OverrunPolicySkip, OverrunPolicyQueue, OverrunPolicyOverlap:
+	default:
+		fail("monitoring.overrun_policy", "invalid value %q", c.Monitoring.OverrunPolicy)
+	}
+	if err := checkSourceAddress(c.Monitoring.SourceAddress); err != nil {
+		fail("monitoring.source_address", "%v", err)
+	}
+	if err := checkProxyURL(c.Monitoring.ProxyURL); err != nil {
+		fail("monitoring.proxy_url", "%v", err)
+	}
+
+	for name := range c.Server.Labels {
+		if err := checkConstLabel(name); err != nil {
+			fail("server.labels", "%v", err)
+		} else if name == JobLabel && len(c.Jobs) > 0 {
+			fail("server.labels", "label %q is set by the jobs", name)
+		}
+	}
+
+	for _, recordType := range c.Defaults.RecordTypes {
+		if !slices.Contains(recordTypes, recordType) {
+			fail("defaults.record_types", "unknown record type %q, supported are %s", recordType, strings.Join(recordTypes, ", "))
+		}
+	}
+	if err := checkZone(c.Defaults.Zone); err != nil {
+		fail("defaults.zone", "%v", err)
+	}
+
+	for i, server := range c.DNSServers {
+		field := server.field(i)
+		errs = append(errs, server.validate(field)...)
+		// The global proxy only applies to the protocols it supports
+		if scheme := proxyScheme(c.Monitoring.ProxyURL); server.ProxyURL == "" && slices.Contains(httpProxySchemes, scheme) && slices.Contains(proxyProtocols, server.Protocol) && server.Protocol != "doh" {
+			fail(field+".proxy_url", "monitoring.proxy_url is an %s proxy, which only doh servers are queried through, set a socks5 proxy_url", scheme)
+		}
+		// Servers of jobs are checked against the job's interval
+		if server.Timeout < 0 {
+			fail(field+".timeout", "must not be negative")
+		} else if server.Timeout > c.Monitoring.Interval && server.Job == "" {
+			fail(field+".timeout", "%v is longer than the interval of %v", server.Timeout, c.Monitoring.Interval)
+		}
+	}
+
+	// Targets sharing an fqdn share their metrics, so they must agree on
+	// the values of their labels
+	targetLabels := make(map[string]map[string]string)
+	targetClasses := make(map[string]string)
+	queried := make(map[string]int)
+	monitored := make(map[string]int)
+	for i, target := range c.Targets {
+		field := target.field(i)
+		if target.FQDN == "" {
+			fail(field+".fqdn", "is required")
+		} else if _, _, err := idnNames(target.FQDN); err != nil {
+			fail(field+".fqdn", "invalid internationalized name %q: %v", target.Spelling(), err)
+		}
+		// The probe_job label is looked up by fqdn, the same name in another job
+		// would share its series
+		if first, ok := monitored[target.FQDN]; ok && c.Targets[first].Job != target.Job {
+			other := c.Targets[first]
+			fail(field+".fqdn", "%s is already monitored by %s of %s, a name can only be monitored by one job", target.FQDN, other.field(first), jobDescription(other.Job))
+			continue
+		} else if !ok {
+			monitored[target.FQDN] = i
+		}
+		for _, recordType := range target.RecordTypes {
+			if !slices.Contains(recordTypes, recordType) {
+				fail(field+".record_types", "unknown record type %q, supported are %s", recordType, strings.Join(recordTypes, ", "))
+				continue
+			}
+			key := target.FQDN + "|" + recordType
+			if first, ok := queried[key]; ok {
+				// Names are compared normalized, the spellings tell which
+				// entries are meant
+				other := c.Targets[first]
+				fail(field+".record_types", "%s %s is already queried by %s (%q and %q are the same name)", target.FQDN, recordType, other.field(first), target.Spelling(), other.Spelling())
+				continue
+			}
+			queried[key] = i
+		}
+		errs = append(errs, target.validateClass(field)...)
+		if class, ok := targetClasses[target.FQDN]; ok && class != target.Class {
+			fail(field+".class", "%s is queried in class %s by another target, targets sharing an fqdn must use the same class", target.FQDN, class)
+		}
+		targetClasses[target.FQDN] = target.Class
+		for _, subnet := range target.ClientSubnets {
+			if _, _, err := net.ParseCIDR(subnet); err != nil {
+				fail(field+".client_subnet", "invalid subnet %q: %v", subnet, err)
+			}
+		}
+		if target.Expect != "" && target.Expect != ExpectNXDomain {
+			fail(field+".expect", "invalid value %q, only %q is supported", target.Expect, ExpectNXDomain)
+		}
+		if target.MaxExportedIPs < 0 {
+			fail(field+".max_exported_ips", "must not be negative")
+		}
+		if target.LatencySLO < 0 {
+			fail(field+".latency_slo", "must not be negative")
+		}
+		if target.QueriesPerProbe < 0 {
+			fail(field+".queries_per_probe", "must not be negative")
+		}
+		if target.Retries != nil && *target.Retries < 0 {
+			fail(field+".retries", "must not be negative")
+		}
+		if target.RetryBackoff < 0 {
+			fail(field+".retry_backoff", "must not be negative")
+		}
+		for _, name := range target.DNSServers {
+			if !slices.ContainsFunc(c.DNSServers, func(s DNSServer) bool { return s.Name == name && s.Job == target.Job }) {
+				fail(field+".dns_servers", "undefined DNS server %q", name)
+			}
+		}
+		for _, tag := range target.ServerTags {
+			if !slices.ContainsFunc(c.DNSServers, func(s DNSServer) bool { return slices.Contains(s.Tags, tag) && s.Job == target.Job }) {
+				fail(field+".server_tags", "no DNS server has tag %q", tag)
+			}
+		}
+		for j, window := range target.MaintenanceWindows {
+			if err := window.validate(); err != nil {
+				fail(fmt.Sprintf("%s.maintenance_windows[%d]", field, j), "%v", err)
+			}
+		}
+		// Targets of jobs are checked against the job's timeout
+		if target.Interval < 0 {
+			fail(field+".interval", "must not be negative")
+		} else if target.Interval > 0 && target.Interval < c.Monitoring.Timeout && target.Job == "" {
+			fail(field+".interval", "%v is shorter than the timeout of %v", target.Interval, c.Monitoring.Timeout)
+		} else if target.Interval > 0 && c.Monitoring.Jitter.Duration >= target.Interval {
+			fail(field+".interval", "%v is not longer than the jitter of %v", target.Interval, c.Monitoring.Jitter)
+		}
+		for name, value := range target.Labels {
+			// Set on the targets of jobs by addJobs, their own labels are
+			// checked by validateJobs
+			if name == JobLabel {
+				if target.Job == "" {
+					fail(field+".labels", "label %q is set by the jobs", name)
+				}
+				continue
+			}
+			if err := checkConstLabel(name); err != nil {
+				fail(field+".labels", "%v", err)
+				continue
+			}
+			if _, ok := c.Server.Labels[name]; ok {
+				fail(field+".labels", "label %q collides with a label of server.labels", name)
+			}
+			if previous, ok := targetLabels[target.FQDN][name]; ok && previous != value {
+				fail(field+".labels", "label %q has different values for the same fqdn", name)
+			}
+			if targetLabels[target.FQDN] == nil {
+				targetLabels[target.FQDN] = make(map[string]string)
+			}
+			targetLabels[target.FQDN][name] = value
+		}
+	}
+
+	for i, zt := range c.ZoneTransfers {
+		field := fmt.Sprintf("zone_transfers[%d]", i)
+		if zt.Zone == "" {
+			fail(field+".zone", "is required")
+		}
+		if err := checkServerAddress(zt.Server); err != nil {
+			fail(field+".server", "%v", err)
+		}
+		if zt.Timeout < 0 {
+			fail(field+".timeout", "must not be negative")
+		}
+		if zt.TSIGKeyName != "" && !slices.Contains(tsigAlgorithms, strings.TrimSuffix(strings.ToLower(zt.TSIGAlgorithm), ".")) {
+			fail(field+".tsig_algorithm", "unsupported algorithm %q", zt.TSIGAlgorithm)
+		}
+		if zt.TSIGSecretFile != "" {
+			if zt.TSIGSecret != "" {
+				fail(field+".tsig_secret_file", "cannot be combined with tsig_secret")
+			}
+			if zt.TSIGKeyName == "" {
+				fail(field+".tsig_secret_file", "requires tsig_key_name")
+			}
+			if err := checkSecretFile(zt.TSIGSecretFile); err != nil {
+				fail(field+".tsig_secret_file", "%v", err)
+			}
+		}
+	}
+
+	errs = append(errs, c.validateJobs()...)
+
+	return errors.Join(errs...)
+}
+
+// validate checks the settings of a DNS server, field is the prefix of the
+// problems it returns
+func (s DNSServer) validate(field string) []error {
+	var errs []error
+	fail := func(name string, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s.%s: %s", field, name, fmt.Sprintf(format, args...)))
+	}
+
+	if s.Address == SystemAddress {
+		if err := checkSystemServer(s); err != nil {
+			fail("address", "%v", err)
+		}
+		return errs
+	}
+	if s.Protocol == "doh" {
+		if err := checkDoHURL(s.Address); err != nil {
+			fail("address", "%v", err)
+		}
+	} else if err := checkServerAddress(s.Address); err != nil {
+		fail("address", "%v", err)
+	}
+	if s.Protocol != "" && !slices.Contains(protocols, s.Protocol) {
+		fail("protocol", "unsupported protocol %q", s.Protocol)
+	}
+	if (s.TLS.CertFile == "") != (s.TLS.KeyFile == "") {
+		fail("tls", "cert_file and key_file must be set together")
+	}
+	if s.TLS != (TLSConfig{}) && !slices.Contains(tlsProtocols, s.Protocol) {
+		fail("tls", "requires protocol dot, doq or doh")
+	}
+	if (len(s.Headers) > 0 || len(s.HeaderFiles) > 0 || s.BearerTokenFile != "") && s.Protocol != "doh" {
+		fail("headers", "headers, header_files and bearer_token_file require protocol doh")
+	}
+	if err := checkSecretFile(s.BearerTokenFile); err != nil {
+		fail("bearer_token_file", "%v", err)
+	}
+	for name, path := range s.HeaderFiles {
+		if _, ok := s.Headers[name]; ok {
+			fail("header_files", "header %q is also set in headers", name)
+		}
+		if err := checkSecretFile(path); err != nil {
+			fail("header_files", "header %q: %v", name, err)
+		}
+	}
+	if err := checkSourceAddress(s.SourceAddress); err != nil {
+		fail("source_address", "%v", err)
+	}
+	if s.TransportFamily != "" && !slices.Contains(transportFamilies, s.TransportFamily) {
+		fail("transport_family", "unsupported transport family %q", s.TransportFamily)
+	}
+	if s.ProxyURL != "" && !slices.Contains(proxyProtocols, s.Protocol) {
+		fail("proxy_url", "requires protocol tcp, dot or doh")
+	}
+	if err := checkProxyURL(s.ProxyURL); err != nil {
+		fail("proxy_url", "%v", err)
+	} else if scheme := proxyScheme(s.ProxyURL); slices.Contains(httpProxySchemes, scheme) && s.Protocol != "doh" {
+		fail("proxy_url", "%s proxies require protocol doh, tcp and dot servers are queried through socks5 proxies", scheme)
+	}
+	return errs
+}
+
+// longestInterval returns the longest interval enabled targets are probed
+// at and the setting it comes from
+func (c *Config) longestInterval() (Duration, string) {
+	longest, field := c.Monitoring.Interval, "monitoring.interval"
+	for i, target := range c.Targets {
+		// The intervals of the targets of jobs are checked on the jobs,
+		// where they come from
+		if target.Job == "" && target.IsEnabled() && target.Interval > longest {
+			longest, field = target.Interval, target.field(i)+".interval"
+		}
+	}
+	for i, job := range c.Jobs {
+		if job.Interval > longest {
+			longest, field = job.Interval, fmt.Sprintf("jobs[%d].interval", i)
+		}
+		for j, target := range job.Targets {
+			if target.IsEnabled() && target.Interval > longest {
+				longest, field = target.Interval, fmt.Sprintf("jobs[%d].targets[%d].interval", i, j)
+			}
+		}
+	}
+	return longest, field
+}
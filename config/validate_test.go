@@ -0,0 +1,56 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReservedLabels(t *testing.T) {
+	for _, name := range []string{"fqdn", "dns_server", "record_type", "error_class", "resolved_fqdn"} {
+		configs := map[string]string{
+			"server.labels":     "server:\n  labels:\n    " + name + ": x\ndns_servers:\n  - address: 1.1.1.1\ntargets:\n  - fqdn: example.com\n",
+			"targets[0].labels": "dns_servers:\n  - address: 1.1.1.1\ntargets:\n  - fqdn: example.com\n    labels:\n      " + name + ": x\n",
+		}
+		for field, content := range configs {
+			_, err := LoadConfig(writeConfig(t, content), LoadOptions{})
+			if err == nil {
+				t.Errorf("%s: label %s accepted, want an error", field, name)
+				continue
+			}
+			if !strings.Contains(err.Error(), field) || !strings.Contains(err.Error(), "collides") {
+				t.Errorf("%s: label %s: error %q does not report the collision", field, name, err)
+			}
+		}
+	}
+}
+
+func TestMetricStalenessTTL(t *testing.T) {
+	const servers = "dns_servers:\n  - address: 1.1.1.1\n"
+	tests := []struct {
+		name    string
+		content string
+		// Setting named in the error, empty when the TTL is valid
+		field string
+	}{
+		{"longer than the interval", "monitoring:\n  interval: 30s\n  metric_staleness_ttl: 2m\n" + servers + "targets:\n  - fqdn: example.com\n", ""},
+		{"not set", "monitoring:\n  interval: 30s\n" + servers + "targets:\n  - fqdn: example.com\n    interval: 1h\n", ""},
+		{"equal to the interval", "monitoring:\n  interval: 1m\n  metric_staleness_ttl: 1m\n" + servers + "targets:\n  - fqdn: example.com\n", "monitoring.interval"},
+		{"shorter than a target interval", "monitoring:\n  interval: 30s\n  metric_staleness_ttl: 2m\n" + servers + "targets:\n  - fqdn: example.com\n  - fqdn: www.example.com\n    interval: 5m\n", "targets[1].interval"},
+		{"disabled target", "monitoring:\n  interval: 30s\n  metric_staleness_ttl: 2m\n" + servers + "targets:\n  - fqdn: example.com\n    interval: 5m\n    enabled: false\n  - fqdn: www.example.com\n", ""},
+		{"shorter than a job interval", "monitoring:\n  interval: 30s\n  metric_staleness_ttl: 2m\n" + servers + "targets:\n  - fqdn: example.com\njobs:\n  - name: slow\n    interval: 3m\n    dns_servers:\n      - address: 10.0.0.53\n    targets:\n      - fqdn: internal.example.com\n", "jobs[0].interval"},
+		{"shorter than a job target interval", "monitoring:\n  interval: 30s\n  metric_staleness_ttl: 2m\n" + servers + "targets:\n  - fqdn: example.com\njobs:\n  - name: slow\n    interval: 1m\n    dns_servers:\n      - address: 10.0.0.53\n    targets:\n      - fqdn: internal.example.com\n      - fqdn: www.internal.example.com\n        interval: 10m\n", "jobs[0].targets[1].interval"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfig(writeConfig(t, tt.content), LoadOptions{})
+			switch {
+			case tt.field == "" && err != nil:
+				t.Errorf("LoadConfig() = %v, want nil", err)
+			case tt.field != "" && err == nil:
+				t.Errorf("LoadConfig() = nil, want an error naming %s", tt.field)
+			case tt.field != "" && (!strings.Contains(err.Error(), "monitoring.metric_staleness_ttl") || !strings.Contains(err.Error(), "longer than "+tt.field)):
+				t.Errorf("LoadConfig() = %v, want an error naming %s", err, tt.field)
+			}
+		})
+	}
+}
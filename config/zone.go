@@ -0,0 +1,29 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// checkZone checks a zone target names are qualified with
+func checkZone(zone string) error {
+	if _, _, err := idnNames(normalizeFQDN(zone)); err != nil {
+		return fmt.Errorf("invalid internationalized name %q: %v", zone, err)
+	}
+	return nil
+}
+
+// qualifyName appends the zone to a normalized target name that is not
+// already fully qualified, i.e. not written with a trailing dot and not
+// within the zone. IP addresses of PTR targets and empty names, which fail
+// validation, are left alone.
+func qualifyName(name, zone string, absolute bool) string {
+	if zone == "" || zone == "." || absolute || name == "" || name == "." || net.ParseIP(name) != nil {
+		return name
+	}
+	if name == zone || strings.HasSuffix(name, "."+zone) {
+		return name
+	}
+	return name + "." + zone
+}
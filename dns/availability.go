@@ -0,0 +1,82 @@
+package dns
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultAvailabilityWindow is the window the availability ratio is
+// computed over when the query does not set one
+const DefaultAvailabilityWindow = 15 * time.Minute
+
+// availabilityWindow holds the outcomes of the lookups of a name, record
+// type and server within the availability window, oldest first
+type availabilityWindow struct {
+	labels   prometheus.Labels
+	attempts []availabilityAttempt
+}
+
+type availabilityAttempt struct {
+	at      time.Time
+	success bool
+}
+
+// recordAvailability adds the outcome of a lookup to its window, drops the
+// attempts that left the window and exposes the share of successful ones.
+// The windows live in memory only, so they start empty after a restart.
+func (r *Resolver) recordAvailability(result *Result, window time.Duration) {
+	if window <= 0 {
+		window = DefaultAvailabilityWindow
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := result.FQDN + "|" + result.RecordType + "|" + result.DNSServer
+	state, ok := r.availability[key]
+	if !ok {
+		state = &availabilityWindow{labels: prometheus.Labels{
+			"fqdn":        result.FQDN,
+			"record_type": result.RecordType,
+			"dns_server":  result.DNSServer,
+		}}
+		r.availability[key] = state
+	}
+
+	ratio := state.add(time.Now(), result.Success, window)
+	r.metrics.AvailabilityRatio.With(state.labels).Set(ratio)
+}
+
+// add records the outcome of a lookup at now and returns the share of
+// successful lookups within window before now. Lookups exactly window old
+// are still within it.
+func (w *availabilityWindow) add(now time.Time, success bool, window time.Duration) float64 {
+	w.attempts = append(w.attempts, availabilityAttempt{at: now, success: success})
+	expired := 0
+	for expired < len(w.attempts) && now.Sub(w.attempts[expired].at) > window {
+		expired++
+	}
+	w.attempts = w.attempts[expired:]
+
+	successes := 0
+	for _, attempt := range w.attempts {
+		if attempt.success {
+			successes++
+		}
+	}
+	return float64(successes) / float64(len(w.attempts))
+}
+
+// forgetAvailability drops the windows matching all of match, after their
+// series were deleted by Resolver.Forget
+func (r *Resolver) forgetAvailability(match prometheus.Labels) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, state := range r.availability {
+		if matchesLabels(state.labels, match) {
+			delete(r.availability, key)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package dns
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAvailabilityWindow(t *testing.T) {
+	const window = time.Minute
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		offset   time.Duration
+		success  bool
+		want     float64
+		attempts int
+	}{
+		{"first failure", 0, false, 0, 1},
+		{"success within the window", 30 * time.Second, true, 0.5, 2},
+		{"failure exactly one window old is kept", window, true, 2.0 / 3, 3},
+		{"failure just older than the window expires", window + time.Nanosecond, true, 1, 3},
+		{"everything but the last expires", 10 * window, false, 0, 1},
+	}
+
+	var w availabilityWindow
+	for _, tt := range tests {
+		got := w.add(start.Add(tt.offset), tt.success, window)
+		if got != tt.want {
+			t.Errorf("%s: ratio = %v, want %v", tt.name, got, tt.want)
+		}
+		if len(w.attempts) != tt.attempts {
+			t.Errorf("%s: %d attempts in the window, want %d", tt.name, len(w.attempts), tt.attempts)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ZoneTransfer describes an AXFR check of a zone against a server
+type ZoneTransfer struct {
+	Zone   string
+	Server Server
+
+	// Optional TSIG key used to sign the transfer request
+	TSIGKeyName   string
+	TSIGAlgorithm string
+	TSIGSecret    string
+	// File the TSIG secret is read from for each transfer, instead of
+	// TSIGSecret
+	TSIGSecretFile string
+}
+
+// Transfer performs an AXFR of the zone and exposes whether it succeeded,
+// how long it took, how many records were transferred and the SOA serial
+// seen. The records themselves are only counted, never exported.
+func (r *Resolver) Transfer(zt ZoneTransfer, timeout time.Duration) error {
+	start := time.Now()
+
+	count, serial, err := transferZone(zt, timeout)
+
+	labels := prometheus.Labels{
+		"zone":       zt.Zone,
+		"dns_server": zt.Server.Label(),
+	}
+	r.metrics.AXFRDuration.With(labels).Set(time.Since(start).Seconds())
+	if err != nil {
+		r.metrics.AXFRSuccess.With(labels).Set(0)
+		return err
+	}
+
+	r.metrics.AXFRSuccess.With(labels).Set(1)
+	r.metrics.AXFRRecordCount.With(labels).Set(float64(count))
+	r.metrics.AXFRSOASerial.With(labels).Set(float64(serial))
+	return nil
+}
+
+// transferZone runs the transfer within timeout and returns the number of
+// records in the zone and its SOA serial
+func transferZone(zt ZoneTransfer, timeout time.Duration) (int, uint32, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, err := zt.Server.dialer("tcp").DialContext(ctx, zt.Server.network("tcp"), zt.Server.dialAddress())
+	if err != nil {
+		return 0, 0, err
+	}
+	// Closing the connection aborts the transfer once the timeout expires
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	msg := new(mdns.Msg)
+	msg.SetAxfr(mdns.Fqdn(zt.Zone))
+
+	transfer := &mdns.Transfer{
+		Conn:         &mdns.Conn{Conn: conn},
+		ReadTimeout:  timeout,
+		WriteTimeout: timeout,
+	}
+	if zt.TSIGKeyName != "" {
+		secret := zt.TSIGSecret
+		if zt.TSIGSecretFile != "" {
+			secret, err = readSecret("TSIG secret", zt.TSIGSecretFile)
+			if err != nil {
+				return 0, 0, err
+			}
+		}
+		keyName := mdns.CanonicalName(zt.TSIGKeyName)
+		msg.SetTsig(keyName, mdns.CanonicalName(zt.TSIGAlgorithm), 300, time.Now().Unix())
+		transfer.TsigSecret = map[string]string{keyName: secret}
+	}
+
+	envelopes, err := transfer.In(msg, zt.Server.dialAddress())
+	if err != nil {
+		conn.Close()
+		return 0, 0, err
+	}
+
+	count := 0
+	var serial uint32
+	var transferErr error
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			transferErr = envelope.Error
+			continue
+		}
+		for _, rr := range envelope.RR {
+			if soa, ok := rr.(*mdns.SOA); ok && count == 0 {
+				serial = soa.Serial
+			}
+			count++
+		}
+	}
+	if transferErr != nil {
+		if ctx.Err() != nil {
+			return 0, 0, fmt.Errorf("AXFR %s from %s: %w", zt.Zone, zt.Server.Address, ctx.Err())
+		}
+		return 0, 0, fmt.Errorf("AXFR %s from %s: %w", zt.Zone, zt.Server.Address, transferErr)
+	}
+
+	// The transfer ends with a repeat of the SOA record
+	if count > 1 {
+		count--
+	}
+	return count, serial, nil
+}
@@ -0,0 +1,57 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// LookupChaos sends a CHAOS class TXT query for name (e.g. version.bind or
+// hostname.bind) to server and exposes the returned strings
+func (r *Resolver) LookupChaos(server Server, name string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	server.doqConns = r.doqConns
+	server.dohClients = r.dohClients
+
+	query := Query{
+		FQDN:       name,
+		RecordType: "TXT",
+		Class:      mdns.ClassCHAOS,
+	}
+	r.scopes.touch(prometheus.Labels{"dns_server": server.Label(), "query": name})
+
+	reply, err := exchange(ctx, server, query, name, mdns.TypeTXT)
+	if err != nil {
+		return err
+	}
+
+	var values []string
+	for _, rr := range reply.msg.Answer {
+		if txt, ok := rr.(*mdns.TXT); ok {
+			values = append(values, strings.Join(txt.Txt, ""))
+		}
+	}
+	if len(values) == 0 {
+		return fmt.Errorf("CHAOS query %s via %s: no TXT record in answer", name, server.Address)
+	}
+
+	series := make([]prometheus.Labels, 0, len(values))
+	for _, value := range values {
+		labels := prometheus.Labels{
+			"dns_server": server.Label(),
+			"query":      name,
+			"value":      value,
+		}
+		r.metrics.ServerChaosInfo.With(labels).Set(1)
+		series = append(series, labels)
+	}
+	r.chaosSeries.replace(server.Label()+"|"+name, series)
+
+	return nil
+}
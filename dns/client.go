@@ -0,0 +1,399 @@
+package dns
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+var (
+	// ErrCNAMEDepthExceeded is returned when the CNAME chain of an answer is
+	// longer than the configured maximum depth
+	ErrCNAMEDepthExceeded = errors.New("CNAME chain too long")
+
+	// ErrCNAMELoop is returned when the CNAME chain of an answer loops
+	ErrCNAMELoop = errors.New("CNAME loop")
+
+	// ErrUnexpectedResolution is returned when a target expected to return
+	// NXDOMAIN resolved
+	ErrUnexpectedResolution = errors.New("expected NXDOMAIN but name resolved")
+)
+
+// rcodeError is returned by exchange when the server answers with a
+// non-success rcode
+type rcodeError struct {
+	fqdn   string
+	server string
+	rcode  int
+}
+
+func (e *rcodeError) Error() string {
+	return fmt.Sprintf("lookup %s on %s: %s", e.fqdn, e.server, mdns.RcodeToString[e.rcode])
+}
+
+// defaultEDNSBufferSize is advertised when an EDNS0 option has to be sent
+// but no buffer size is configured for the server
+const defaultEDNSBufferSize = 1232
+
+// reply is the outcome of an exchange with a DNS server
+type reply struct {
+	// Response message, nil when no response was received
+	msg *mdns.Msg
+	// Size of the response message on the wire
+	size int
+	// Whether the UDP response had the TC flag set
+	truncated bool
+	// Whether the question in the response matched the randomized case of
+	// the query name exactly (only meaningful with Query.RandomizeCase)
+	casePreserved bool
+}
+
+// exchange sends a single query for fqdn and qtype to server. EDNS0 options
+// requested by query are added to the message. Truncated Do53 responses are
+// retried over TCP, and whether the UDP response was truncated is reported
+// separately. Responses with a non-success rcode are returned together with
+// an error. The returned reply is never nil.
+func exchange(ctx context.Context, server Server, query Query, fqdn string, qtype uint16) (*reply, error) {
+	r := &reply{}
+	if server.Address == "" {
+		return r, fmt.Errorf("no DNS server address for %s query", mdns.TypeToString[qtype])
+	}
+	if server.System() {
+		return r, fmt.Errorf("%s queries are not supported by the system resolver", mdns.TypeToString[qtype])
+	}
+
+	msg := new(mdns.Msg)
+	msg.SetQuestion(mdns.Fqdn(fqdn), qtype)
+	msg.RecursionDesired = !query.noRecursion
+	if query.Class != 0 {
+		msg.Question[0].Qclass = query.Class
+	}
+	if query.RandomizeCase {
+		msg.Question[0].Name = randomizeCase(msg.Question[0].Name)
+	}
+
+	var options []mdns.EDNS0
+	if query.ClientSubnet != "" {
+		subnet, err := clientSubnetOption(query.ClientSubnet)
+		if err != nil {
+			return r, err
+		}
+		options = append(options, subnet)
+	}
+	if server.NSID {
+		options = append(options, &mdns.EDNS0_NSID{Code: mdns.EDNS0NSID})
+	}
+	if query.cookie != nil {
+		options = append(options, query.cookie)
+	}
+
+	client := &mdns.Client{Net: server.network("udp")}
+	switch server.Protocol {
+	case ProtocolTCP:
+		client.Net = server.network("tcp")
+	case ProtocolDoT:
+		client.Net = server.network("tcp") + "-tls"
+		client.TLSConfig = server.TLSConfig
+	}
+	client.Dialer = server.dialer(client.Net)
+	if server.EDNSBufferSize > 0 || len(options) > 0 {
+		bufferSize := server.EDNSBufferSize
+		if bufferSize == 0 {
+			bufferSize = defaultEDNSBufferSize
+		}
+		msg.SetEdns0(bufferSize, false)
+		opt := msg.IsEdns0()
+		opt.Option = append(opt.Option, options...)
+		client.UDPSize = bufferSize
+	}
+
+	var resp *mdns.Msg
+	var size int
+	var err error
+	switch server.Protocol {
+	case ProtocolDoQ:
+		resp, size, err = doqExchange(ctx, server, msg)
+	case ProtocolDoH:
+		resp, size, err = dohExchange(ctx, server, msg)
+	default:
+		resp, size, err = roundTrip(ctx, client, server, msg)
+	}
+	if err != nil {
+		return r, err
+	}
+
+	// Only Do53 falls back to TCP, a forced UDP transport reports the
+	// truncated response as is
+	r.truncated = resp.Truncated
+	if r.truncated && (server.Protocol == "" || server.Protocol == ProtocolDo53) {
+		client.Net = server.network("tcp")
+		client.Dialer = server.dialer(client.Net)
+		resp, size, err = roundTrip(ctx, client, server, msg)
+		if err != nil {
+			return r, err
+		}
+	}
+
+	r.msg = resp
+	r.size = size
+	r.casePreserved = len(resp.Question) > 0 && resp.Question[0].Name == msg.Question[0].Name
+
+	if resp.Rcode != mdns.RcodeSuccess {
+		return r, &rcodeError{fqdn: fqdn, server: server.Address, rcode: resp.Rcode}
+	}
+	return r, nil
+}
+
+// randomizeCase flips the case of the letters in name at random (DNS 0x20)
+func randomizeCase(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if ('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') && rand.IntN(2) == 1 {
+			b[i] = c ^ 0x20
+		}
+	}
+	return string(b)
+}
+
+// clientSubnetOption builds an EDNS Client Subnet option for a CIDR
+func clientSubnetOption(cidr string) (*mdns.EDNS0_SUBNET, error) {
+	_, subnet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client subnet %q: %w", cidr, err)
+	}
+
+	ones, _ := subnet.Mask.Size()
+	option := &mdns.EDNS0_SUBNET{
+		Code:          mdns.EDNS0SUBNET,
+		SourceNetmask: uint8(ones),
+	}
+	if ip4 := subnet.IP.To4(); ip4 != nil {
+		option.Family = 1
+		option.Address = ip4
+	} else {
+		option.Family = 2
+		option.Address = subnet.IP
+	}
+	return option, nil
+}
+
+// responseNSID returns the NSID returned in resp, decoded to text when it
+// is printable and as hex otherwise
+func responseNSID(resp *mdns.Msg) string {
+	opt := resp.IsEdns0()
+	if opt == nil {
+		return ""
+	}
+
+	for _, option := range opt.Option {
+		nsid, ok := option.(*mdns.EDNS0_NSID)
+		if !ok {
+			continue
+		}
+		decoded, err := hex.DecodeString(nsid.Nsid)
+		if err != nil {
+			return nsid.Nsid
+		}
+		for _, c := range decoded {
+			if c < 0x20 || c > 0x7e {
+				return nsid.Nsid
+			}
+		}
+		return string(decoded)
+	}
+	return ""
+}
+
+// roundTrip performs one request/response exchange over a new connection.
+// Unlike mdns.Client.Exchange it also returns the length of the received
+// message.
+func roundTrip(ctx context.Context, client *mdns.Client, server Server, msg *mdns.Msg) (*mdns.Msg, int, error) {
+	conn, err := server.dial(ctx, client)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err := conn.WriteMsg(msg); err != nil {
+		return nil, 0, err
+	}
+
+	for {
+		raw, err := conn.ReadMsgHeader(nil)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		resp := new(mdns.Msg)
+		if err := resp.Unpack(raw); err != nil {
+			return nil, 0, err
+		}
+		// Ignore replies to earlier queries that timed out
+		if resp.Id == msg.Id {
+			return resp, len(raw), nil
+		}
+	}
+}
+
+// lookupRaw queries server directly for result.RecordType and fills the
+// record fields, TTLs and raw response of result
+func lookupRaw(ctx context.Context, server Server, query Query, result *Result) error {
+	qtype := mdns.StringToType[result.RecordType]
+
+	// IP address targets are converted to their in-addr.arpa / ip6.arpa name
+	qname := result.name
+	if qtype == mdns.TypePTR && net.ParseIP(qname) != nil {
+		reverse, err := mdns.ReverseAddr(qname)
+		if err != nil {
+			return err
+		}
+		qname = reverse
+	}
+
+	reply, err := exchange(ctx, server, query, qname, qtype)
+	result.Truncated = reply.truncated
+	result.CasePreserved = reply.casePreserved
+	resp := reply.msg
+	if resp != nil {
+		result.Response = resp
+		result.ResponseSize = reply.size
+		result.NSID = responseNSID(resp)
+		result.MinTTL, result.MaxTTL = answerTTLs(resp)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, rr := range resp.Answer {
+		switch rr := rr.(type) {
+		case *mdns.A:
+			if qtype == mdns.TypeA {
+				result.IPs = append(result.IPs, net.IPAddr{IP: rr.A})
+			}
+		case *mdns.AAAA:
+			if qtype == mdns.TypeAAAA {
+				result.IPs = append(result.IPs, net.IPAddr{IP: rr.AAAA})
+			}
+		case *mdns.MX:
+			result.MX = append(result.MX, &net.MX{Host: rr.Mx, Pref: rr.Preference})
+		case *mdns.TXT:
+			result.TXT = append(result.TXT, strings.Join(rr.Txt, ""))
+		case *mdns.NS:
+			result.NS = append(result.NS, &net.NS{Host: rr.Ns})
+		case *mdns.SOA:
+			result.SOA = rr
+		case *mdns.PTR:
+			result.PTR = append(result.PTR, rr.Ptr)
+		case *mdns.SRV:
+			result.SRV = append(result.SRV, rr)
+		case *mdns.HTTPS:
+			result.SVCB = append(result.SVCB, &rr.SVCB)
+		case *mdns.SVCB:
+			result.SVCB = append(result.SVCB, rr)
+		case *mdns.DNSKEY:
+			result.DNSKEY = append(result.DNSKEY, rr)
+		}
+	}
+
+	// Match net.Resolver, which treats an empty answer as an error
+	switch qtype {
+	case mdns.TypeA, mdns.TypeAAAA:
+		length, err := cnameChainLength(resp, qname, query.MaxCNAMEDepth)
+		result.CNAMEChainLength = length
+		if err != nil {
+			return fmt.Errorf("lookup %s on %s: %w", result.FQDN, result.DNSServer, err)
+		}
+		if len(result.IPs) == 0 {
+			return noSuchHost(result)
+		}
+	case mdns.TypeMX:
+		if len(result.MX) == 0 {
+			return noSuchHost(result)
+		}
+	case mdns.TypeTXT:
+		if len(result.TXT) == 0 {
+			return noSuchHost(result)
+		}
+	case mdns.TypeNS:
+		if len(result.NS) == 0 {
+			return noSuchHost(result)
+		}
+	case mdns.TypeDNSKEY:
+		if len(result.DNSKEY) == 0 {
+			return noSuchHost(result)
+		}
+	case mdns.TypeSOA:
+		if result.SOA == nil {
+			return fmt.Errorf("lookup %s on %s: no SOA record in answer", result.FQDN, result.DNSServer)
+		}
+	}
+	return nil
+}
+
+// cnameChainLength follows the CNAME chain in the answer section starting at
+// qname and returns the number of CNAMEs. Since only the records already in
+// the answer are walked, loops are detected rather than followed.
+func cnameChainLength(resp *mdns.Msg, qname string, maxDepth int) (int, error) {
+	cnames := make(map[string]string)
+	for _, rr := range resp.Answer {
+		if cname, ok := rr.(*mdns.CNAME); ok {
+			cnames[strings.ToLower(cname.Hdr.Name)] = strings.ToLower(cname.Target)
+		}
+	}
+
+	length := 0
+	visited := make(map[string]bool)
+	name := strings.ToLower(mdns.Fqdn(qname))
+	for {
+		target, ok := cnames[name]
+		if !ok {
+			return length, nil
+		}
+		if visited[name] {
+			return length, ErrCNAMELoop
+		}
+		visited[name] = true
+
+		length++
+		if maxDepth > 0 && length > maxDepth {
+			return length, ErrCNAMEDepthExceeded
+		}
+		name = target
+	}
+}
+
+// noSuchHost returns the error reported for an empty answer
+func noSuchHost(result *Result) error {
+	return fmt.Errorf("lookup %s on %s: no such host", result.FQDN, result.DNSServer)
+}
+
+// answerTTLs returns the minimum and maximum TTL in the answer section
+func answerTTLs(resp *mdns.Msg) (time.Duration, time.Duration) {
+	if len(resp.Answer) == 0 {
+		return 0, 0
+	}
+
+	minTTL := resp.Answer[0].Header().Ttl
+	maxTTL := minTTL
+	for _, rr := range resp.Answer[1:] {
+		ttl := rr.Header().Ttl
+		if ttl < minTTL {
+			minTTL = ttl
+		}
+		if ttl > maxTTL {
+			maxTTL = ttl
+		}
+	}
+	return time.Duration(minTTL) * time.Second, time.Duration(maxTTL) * time.Second
+}
@@ -0,0 +1,83 @@
+package dns
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+
+	mdns "github.com/miekg/dns"
+)
+
+// cookieJar keeps the DNS cookies (RFC 7873) exchanged with each server.
+// The client cookie is generated once per server and kept for the lifetime
+// of the process, so server cookies stay valid between cycles.
+type cookieJar struct {
+	mu      sync.Mutex
+	cookies map[string]*cookie
+}
+
+// cookie holds the hex encoded client and server cookies for one server
+type cookie struct {
+	client string
+	server string
+}
+
+func newCookieJar() *cookieJar {
+	return &cookieJar{
+		cookies: make(map[string]*cookie),
+	}
+}
+
+// option returns the COOKIE option to send to the server at address,
+// including the last server cookie it returned
+func (j *cookieJar) option(address string) *mdns.EDNS0_COOKIE {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	c, ok := j.cookies[address]
+	if !ok {
+		client := make([]byte, 8)
+		rand.Read(client)
+		c = &cookie{client: hex.EncodeToString(client)}
+		j.cookies[address] = c
+	}
+
+	return &mdns.EDNS0_COOKIE{
+		Code:   mdns.EDNS0COOKIE,
+		Cookie: c.client + c.server,
+	}
+}
+
+// update remembers the server cookie returned in resp and reports whether
+// the response echoed our client cookie together with a valid server cookie
+func (j *cookieJar) update(address string, resp *mdns.Msg) bool {
+	opt := resp.IsEdns0()
+	if opt == nil {
+		return false
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	c, ok := j.cookies[address]
+	if !ok {
+		return false
+	}
+
+	for _, option := range opt.Option {
+		returned, ok := option.(*mdns.EDNS0_COOKIE)
+		if !ok {
+			continue
+		}
+
+		// Client cookie (8 bytes) followed by a server cookie of 8 to 32 bytes
+		value := strings.ToLower(returned.Cookie)
+		if len(value) < 32 || len(value) > 80 || value[:16] != c.client {
+			return false
+		}
+		c.server = value[16:]
+		return true
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// errNotDelegated is returned when the servers of the parent zone answer
+// for a zone themselves instead of referring to its name servers
+var errNotDelegated = errors.New("no delegation found at the parent")
+
+// Delegation describes a comparison of the NS records of a zone served by
+// its parent (the delegation) with those served by the zone itself
+type Delegation struct {
+	Zone string
+	// Name of the zone in queries when it differs from Zone, see Query.Name
+	Name string
+
+	// Where the search for the parent starts, see Trace
+	StartZone    string
+	StartServers []string
+
+	// Local address queries are sent from ("" = chosen by the system)
+	SourceAddress string
+}
+
+// name returns the name of the zone in queries
+func (d Delegation) name() string {
+	if d.Name != "" {
+		return d.Name
+	}
+	return d.Zone
+}
+
+// delegationReport lists the differences found by a delegation check
+type delegationReport struct {
+	// Name servers listed at the child but not at the parent, and vice versa
+	missingAtParent []string
+	missingAtChild  []string
+	// Name servers whose glue differs from the authoritative addresses
+	glueMismatches []string
+}
+
+// CheckDelegation compares the NS set and glue of the zone at its parent
+// with the NS set and addresses served by the zone's own servers. Every
+// difference found increments a counter, and the zone is reported
+// consistent only when there are none.
+func (r *Resolver) CheckDelegation(delegation Delegation, timeout time.Duration) error {
+	report, err := checkDelegation(delegation, timeout)
+
+	labels := prometheus.Labels{"fqdn": delegation.Zone}
+	if err != nil {
+		r.metrics.DelegationCheckSuccess.With(labels).Set(0)
+		return err
+	}
+	r.metrics.DelegationCheckSuccess.With(labels).Set(1)
+
+	for _, ns := range report.missingAtParent {
+		r.metrics.DelegationMissingNSTotal.With(prometheus.Labels{
+			"fqdn":    delegation.Zone,
+			"ns":      ns,
+			"missing": "parent",
+		}).Inc()
+	}
+	for _, ns := range report.missingAtChild {
+		r.metrics.DelegationMissingNSTotal.With(prometheus.Labels{
+			"fqdn":    delegation.Zone,
+			"ns":      ns,
+			"missing": "child",
+		}).Inc()
+	}
+	for _, ns := range report.glueMismatches {
+		r.metrics.GlueMismatchTotal.With(prometheus.Labels{
+			"fqdn": delegation.Zone,
+			"ns":   ns,
+		}).Inc()
+	}
+
+	consistent := len(report.missingAtParent) == 0 && len(report.missingAtChild) == 0 && len(report.glueMismatches) == 0
+	r.metrics.DelegationConsistent.With(labels).Set(boolToFloat(consistent))
+	return nil
+}
+
+// checkDelegation fetches the referral to the zone from its parent and the
+// zone's own NS records and compares them
+func checkDelegation(delegation Delegation, timeout time.Duration) (*delegationReport, error) {
+	zone := mdns.CanonicalName(delegation.name())
+
+	startZone, startServers := traceStart(delegation.StartZone, delegation.StartServers, timeout)
+	_, referral, child, err := followReferrals(zone, mdns.TypeNS, startZone, startServers, zone, delegation.SourceAddress, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("delegation of %s: %w", delegation.Zone, err)
+	}
+	if child != zone {
+		return nil, fmt.Errorf("delegation of %s: %w", delegation.Zone, errNotDelegated)
+	}
+
+	var parentNS []string
+	for _, rr := range referral.Ns {
+		if ns, ok := rr.(*mdns.NS); ok && mdns.CanonicalName(ns.Hdr.Name) == zone {
+			parentNS = append(parentNS, mdns.CanonicalName(ns.Ns))
+		}
+	}
+
+	servers := referralServers(referral, zone, timeout)
+	resp, err := queryAuthoritative(servers, zone, mdns.TypeNS, delegation.SourceAddress, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("NS records of %s: %w", delegation.Zone, err)
+	}
+	var childNS []string
+	for _, rr := range resp.Answer {
+		if ns, ok := rr.(*mdns.NS); ok {
+			childNS = append(childNS, mdns.CanonicalName(ns.Ns))
+		}
+	}
+
+	report := &delegationReport{}
+	for _, ns := range childNS {
+		if !slices.Contains(parentNS, ns) {
+			report.missingAtParent = append(report.missingAtParent, ns)
+		}
+	}
+	for _, ns := range parentNS {
+		if !slices.Contains(childNS, ns) {
+			report.missingAtChild = append(report.missingAtChild, ns)
+		}
+	}
+
+	// Only families with glue are compared, glue is optional for name
+	// servers outside the zone
+	for _, ns := range parentNS {
+		for _, qtype := range []uint16{mdns.TypeA, mdns.TypeAAAA} {
+			glue := recordAddresses(referral.Extra, ns, qtype)
+			if len(glue) == 0 {
+				continue
+			}
+			var addresses []string
+			if resp, err := queryAuthoritative(servers, ns, qtype, delegation.SourceAddress, timeout); err == nil {
+				addresses = recordAddresses(resp.Answer, ns, qtype)
+			}
+			if !slices.Equal(glue, addresses) {
+				report.glueMismatches = append(report.glueMismatches, ns)
+				break
+			}
+		}
+	}
+	return report, nil
+}
+
+// queryAuthoritative sends a non-recursive query to each server in turn and
+// returns the first authoritative response
+func queryAuthoritative(servers []string, name string, qtype uint16, source string, timeout time.Duration) (*mdns.Msg, error) {
+	err := errNoServerAddress
+	for _, server := range servers {
+		var resp *mdns.Msg
+		resp, _, _, err = queryTraceServer(server, name, name, qtype, source, timeout)
+		if err != nil {
+			continue
+		}
+		if !resp.Authoritative {
+			err = fmt.Errorf("%s: answer is not authoritative", server)
+			continue
+		}
+		return resp, nil
+	}
+	return nil, err
+}
+
+// recordAddresses returns the sorted addresses of the A or AAAA records of
+// name in rrs
+func recordAddresses(rrs []mdns.RR, name string, qtype uint16) []string {
+	var addresses []string
+	for _, rr := range rrs {
+		if rr.Header().Rrtype != qtype || mdns.CanonicalName(rr.Header().Name) != name {
+			continue
+		}
+		var ip net.IP
+		switch rr := rr.(type) {
+		case *mdns.A:
+			ip = rr.A
+		case *mdns.AAAA:
+			ip = rr.AAAA
+		}
+		addresses = append(addresses, ip.String())
+	}
+	slices.Sort(addresses)
+	return addresses
+}
@@ -0,0 +1,174 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+
+	mdns "github.com/miekg/dns"
+)
+
+// dohMediaType is the content type of DNS messages over HTTPS (RFC 8484)
+const dohMediaType = "application/dns-message"
+
+// dohPool keeps one HTTP client per DoH server so connections are reused
+// across queries and cycles
+type dohPool struct {
+	mu      sync.Mutex
+	clients map[dohClientKey]*http.Client
+}
+
+// dohClientKey identifies the settings a DoH client was created with. A
+// server whose connection settings changed on a reload gets a new client
+// rather than the connections made with the previous ones.
+type dohClientKey struct {
+	label           string
+	sourceAddress   string
+	transportFamily string
+	proxyURL        string
+	// Built for every configuration, so the certificate files are read
+	// again on reloads
+	tlsConfig *tls.Config
+}
+
+func newDoHPool() *dohPool {
+	return &dohPool{clients: make(map[dohClientKey]*http.Client)}
+}
+
+// get returns the client for server, creating it on first use
+func (p *dohPool) get(server Server) *http.Client {
+	key := dohClientKey{
+		label:           server.Label(),
+		sourceAddress:   server.SourceAddress,
+		transportFamily: server.TransportFamily,
+		tlsConfig:       server.TLSConfig,
+	}
+	if server.ProxyURL != nil {
+		key.proxyURL = server.ProxyURL.String()
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if client, ok := p.clients[key]; ok {
+		return client
+	}
+	client := newDoHClient(server)
+	p.clients[key] = client
+	return client
+}
+
+// reset closes the idle connections of every client and forgets them
+func (p *dohPool) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, client := range p.clients {
+		client.CloseIdleConnections()
+		delete(p.clients, key)
+	}
+}
+
+// newDoHClient returns an HTTP client whose connections honour the source
+// address, transport family, proxy and TLS settings of server. HTTP(S)
+// proxies are asked to CONNECT to the server, SOCKS5 proxies are dialed
+// through.
+func newDoHClient(server Server) *http.Client {
+	httpProxy := server.ProxyURL != nil && (server.ProxyURL.Scheme == "http" || server.ProxyURL.Scheme == "https")
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			if server.ProxyURL != nil && !httpProxy {
+				return server.dialProxy(ctx, address)
+			}
+			conn, err := server.dialer("tcp").DialContext(ctx, server.network("tcp"), address)
+			// With an HTTP proxy, only the proxy is dialed
+			if err != nil && httpProxy {
+				return nil, fmt.Errorf("%w: %w", ErrProxy, err)
+			}
+			return conn, err
+		},
+		ForceAttemptHTTP2: true,
+	}
+	if httpProxy {
+		transport.Proxy = http.ProxyURL(server.ProxyURL)
+		transport.OnProxyConnectResponse = func(_ context.Context, _ *url.URL, _ *http.Request, resp *http.Response) error {
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("%w: CONNECT: HTTP status %d", ErrProxy, resp.StatusCode)
+			}
+			return nil
+		}
+	}
+	if server.TLSConfig != nil {
+		transport.TLSClientConfig = server.TLSConfig.Clone()
+	}
+	return &http.Client{Transport: transport}
+}
+
+// dohExchange POSTs msg to a DoH server and returns the response and its
+// size. Header values and the bearer token are never included in errors.
+func dohExchange(ctx context.Context, server Server, msg *mdns.Msg) (*mdns.Msg, int, error) {
+	// The message ID is sent as 0 so responses can be cached, as RFC 8484
+	// recommends, and restored on the response
+	id := msg.Id
+	msg.Id = 0
+	packed, err := msg.Pack()
+	msg.Id = id
+	if err != nil {
+		return nil, 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, server.Address, bytes.NewReader(packed))
+	if err != nil {
+		return nil, 0, err
+	}
+	for name, value := range server.Headers {
+		req.Header.Set(name, value)
+	}
+	for name, path := range server.HeaderFiles {
+		value, err := readSecret("header "+name, path)
+		if err != nil {
+			return nil, 0, err
+		}
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", dohMediaType)
+	req.Header.Set("Accept", dohMediaType)
+	if server.BearerTokenFile != "" {
+		token, err := readSecret("bearer token", server.BearerTokenFile)
+		if err != nil {
+			return nil, 0, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	client := newDoHClient(server)
+	if server.dohClients != nil {
+		client = server.dohClients.get(server)
+	}
+	httpResp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		return nil, 0, fmt.Errorf("DoH server %s: HTTP status %d", server.Label(), httpResp.StatusCode)
+	}
+	raw, err := io.ReadAll(io.LimitReader(httpResp.Body, mdns.MaxMsgSize))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	resp := new(mdns.Msg)
+	if err := resp.Unpack(raw); err != nil {
+		return nil, 0, err
+	}
+	resp.Id = id
+	return resp, len(raw), nil
+}
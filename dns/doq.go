@@ -0,0 +1,230 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	mdns "github.com/miekg/dns"
+	"github.com/quic-go/quic-go"
+)
+
+// doqALPN is the ALPN token of DNS over QUIC (RFC 9250)
+const doqALPN = "doq"
+
+// ErrQUICHandshake is returned when the QUIC connection to a DoQ server
+// cannot be established
+var ErrQUICHandshake = errors.New("QUIC handshake failed")
+
+// doqPool keeps one QUIC connection per DoQ server across cycles, so the
+// queries measure query latency rather than handshake latency. The cycles
+// of several intervals share the pool, a connection is therefore only
+// closed once no lookup uses it anymore.
+type doqPool struct {
+	mu      sync.Mutex
+	servers map[string]*doqServer
+	// Lookups using each connection, pooled or retired
+	users map[*quic.Conn]int
+}
+
+// doqServer is the pooled connection to one server. Its dial lock is held
+// during the handshake, so concurrent lookups of the server share one
+// connection while lookups of other servers go ahead.
+type doqServer struct {
+	dial sync.Mutex
+	// Guarded by the pool's mu
+	conn *quic.Conn
+}
+
+func newDoQPool() *doqPool {
+	return &doqPool{servers: make(map[string]*doqServer), users: make(map[*quic.Conn]int)}
+}
+
+// get returns the pooled connection to server, dialing a new one if there
+// is none or the previous one was closed. The connection must be handed
+// back with release.
+func (p *doqPool) get(ctx context.Context, server Server) (*quic.Conn, error) {
+	p.mu.Lock()
+	s, ok := p.servers[server.Label()]
+	if !ok {
+		s = &doqServer{}
+		p.servers[server.Label()] = s
+	}
+	p.mu.Unlock()
+
+	s.dial.Lock()
+	defer s.dial.Unlock()
+	if conn := p.use(s); conn != nil {
+		return conn, nil
+	}
+	conn, err := dialDoQ(ctx, server)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	s.conn = conn
+	p.users[conn]++
+	p.mu.Unlock()
+	return conn, nil
+}
+
+// use returns the connection of s unless it was closed, counting the
+// lookup as its user
+func (p *doqPool) use(s *doqServer) *quic.Conn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if s.conn != nil && s.conn.Context().Err() != nil {
+		s.conn = nil
+	}
+	if s.conn != nil {
+		p.users[s.conn]++
+	}
+	return s.conn
+}
+
+// release hands back conn after a lookup of server. A failed query retires
+// the connection, so later lookups dial a new one. Retired connections are
+// closed with their last lookup.
+func (p *doqPool) release(server Server, conn *quic.Conn, failed bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s := p.servers[server.Label()]
+	if failed && s != nil && s.conn == conn {
+		s.conn = nil
+	}
+	p.users[conn]--
+	if p.users[conn] == 0 && (s == nil || s.conn != conn) {
+		delete(p.users, conn)
+		conn.CloseWithError(0, "")
+	}
+}
+
+// reset retires every pooled connection, closing those no lookup uses
+func (p *doqPool) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, s := range p.servers {
+		if s.conn != nil && p.users[s.conn] == 0 {
+			delete(p.users, s.conn)
+			s.conn.CloseWithError(0, "")
+		}
+		s.conn = nil
+	}
+}
+
+// dialDoQ opens a QUIC connection to a DoQ server
+func dialDoQ(ctx context.Context, server Server) (*quic.Conn, error) {
+	tlsConfig := &tls.Config{}
+	if server.TLSConfig != nil {
+		tlsConfig = server.TLSConfig.Clone()
+	}
+	tlsConfig.NextProtos = []string{doqALPN}
+
+	// The UDP socket is opened here to bind it to the source address and
+	// address family, it is closed together with the connection
+	remote, err := net.ResolveUDPAddr(server.network("udp"), server.dialAddress())
+	if err != nil {
+		return nil, err
+	}
+	var local *net.UDPAddr
+	if server.SourceAddress != "" {
+		local = &net.UDPAddr{IP: net.ParseIP(server.SourceAddress)}
+	}
+	packetConn, err := net.ListenUDP(server.network("udp"), local)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := quic.Dial(ctx, packetConn, remote, tlsConfig, &quic.Config{})
+	if err != nil {
+		packetConn.Close()
+		return nil, fmt.Errorf("%w: %w", ErrQUICHandshake, err)
+	}
+	context.AfterFunc(conn.Context(), func() { packetConn.Close() })
+	return conn, nil
+}
+
+// doqExchange sends msg to a DoQ server on a new stream and returns the
+// response and its size. The connection comes from the resolver's pool
+// unless the server forces a fresh connection per query.
+func doqExchange(ctx context.Context, server Server, msg *mdns.Msg) (*mdns.Msg, int, error) {
+	pool := server.doqConns
+	if server.DoQFreshConnection {
+		pool = nil
+	}
+
+	var conn *quic.Conn
+	var err error
+	if pool != nil {
+		conn, err = pool.get(ctx, server)
+	} else {
+		conn, err = dialDoQ(ctx, server)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if pool == nil {
+		defer conn.CloseWithError(0, "")
+	}
+
+	resp, size, err := doqRoundTrip(ctx, conn, msg)
+	if pool != nil {
+		pool.release(server, conn, err != nil)
+	}
+	return resp, size, err
+}
+
+// doqRoundTrip performs one request/response exchange on a new stream of
+// conn. The message ID is sent as 0 as RFC 9250 requires and restored on the
+// response.
+func doqRoundTrip(ctx context.Context, conn *quic.Conn, msg *mdns.Msg) (*mdns.Msg, int, error) {
+	id := msg.Id
+	msg.Id = 0
+	packed, err := msg.Pack()
+	msg.Id = id
+	if err != nil {
+		return nil, 0, err
+	}
+
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer stream.CancelRead(0)
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	// Messages are prefixed with their length like over TCP, and the stream
+	// is closed for writing after the single query
+	buf := make([]byte, 2+len(packed))
+	binary.BigEndian.PutUint16(buf, uint16(len(packed)))
+	copy(buf[2:], packed)
+	if _, err := stream.Write(buf); err != nil {
+		return nil, 0, err
+	}
+	stream.Close()
+
+	var length [2]byte
+	if _, err := io.ReadFull(stream, length[:]); err != nil {
+		return nil, 0, err
+	}
+	raw := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(stream, raw); err != nil {
+		return nil, 0, err
+	}
+
+	resp := new(mdns.Msg)
+	if err := resp.Unpack(raw); err != nil {
+		return nil, 0, err
+	}
+	resp.Id = id
+	return resp, len(raw), nil
+}
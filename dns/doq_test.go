@@ -0,0 +1,146 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// startDoQServer serves DoQ on a UDP port of the loopback interface with
+// the test certificate of net/http/httptest, accepting connections without
+// answering queries, and returns a server trusting it
+func startDoQServer(t *testing.T) Server {
+	t.Helper()
+	https := httptest.NewTLSServer(nil)
+	t.Cleanup(https.Close)
+	roots := x509.NewCertPool()
+	roots.AddCert(https.Certificate())
+
+	listener, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: https.TLS.Certificates,
+		NextProtos:   []string{doqALPN},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			if _, err := listener.Accept(context.Background()); err != nil {
+				return
+			}
+		}
+	}()
+	return Server{
+		Name:      "doq",
+		Address:   listener.Addr().String(),
+		Protocol:  ProtocolDoQ,
+		TLSConfig: &tls.Config{RootCAs: roots, ServerName: "example.com"},
+	}
+}
+
+func TestDoQPoolDialsServersConcurrently(t *testing.T) {
+	pool := newDoQPool()
+	live := startDoQServer(t)
+
+	// A server that never completes the handshake keeps its dial waiting
+	// until the lookup's timeout
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	stalled := Server{Name: "silent", Address: silent.LocalAddr().String(), Protocol: ProtocolDoQ, TLSConfig: live.TLSConfig}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	dialed := make(chan error, 1)
+	go func() {
+		_, err := pool.get(ctx, stalled)
+		dialed <- err
+	}()
+	buf := make([]byte, 1500)
+	silent.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := silent.ReadFrom(buf); err != nil {
+		t.Fatalf("no handshake with the silent server: %v", err)
+	}
+
+	// Lookups of other servers do not wait for it
+	start := time.Now()
+	liveCtx, liveCancel := context.WithTimeout(context.Background(), time.Second)
+	defer liveCancel()
+	conn, err := pool.get(liveCtx, live)
+	if err != nil {
+		t.Fatalf("dialing %s while %s is dialed: %v", live.Address, stalled.Address, err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("dialing %s took %v while %s was dialed", live.Address, elapsed, stalled.Address)
+	}
+
+	// Further lookups of the server share the connection
+	again, err := pool.get(liveCtx, live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != conn {
+		t.Errorf("second lookup of %s dialed a new connection", live.Address)
+	}
+
+	pool.release(live, conn, false)
+	pool.release(live, again, false)
+
+	select {
+	case err := <-dialed:
+		t.Fatalf("dial of the silent server returned early: %v", err)
+	default:
+	}
+	cancel()
+	if err := <-dialed; err == nil {
+		t.Errorf("dial of the silent server succeeded")
+	}
+	pool.reset()
+}
+
+func TestDoQPoolReset(t *testing.T) {
+	pool := newDoQPool()
+	server := startDoQServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// A connection in use stays open when the pool is reset, and is closed
+	// with its last lookup
+	inUse, err := pool.get(ctx, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.reset()
+	if err := inUse.Context().Err(); err != nil {
+		t.Fatalf("connection in use closed by reset: %v", err)
+	}
+	fresh, err := pool.get(ctx, server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh == inUse {
+		t.Fatal("lookup after reset got the retired connection")
+	}
+	pool.release(server, inUse, false)
+	if inUse.Context().Err() == nil {
+		t.Error("retired connection still open after its last lookup")
+	}
+
+	// An idle connection is kept until reset
+	pool.release(server, fresh, false)
+	if err := fresh.Context().Err(); err != nil {
+		t.Fatalf("idle pooled connection closed: %v", err)
+	}
+	pool.reset()
+	if fresh.Context().Err() == nil {
+		t.Error("idle connection still open after reset")
+	}
+}
@@ -0,0 +1,124 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	mdns "github.com/miekg/dns"
+)
+
+// lookupDS fetches the DS records of result.name from the authoritative
+// servers of its parent zone. The parent zone, its name servers and their
+// addresses are looked up through server, which therefore has to be a
+// recursive resolver.
+func lookupDS(ctx context.Context, server Server, query Query, result *Result) error {
+	fqdn := mdns.Fqdn(result.name)
+
+	parent := query.ParentZone
+	if parent == "" {
+		var err error
+		parent, err = findParentZone(ctx, server, fqdn)
+		if err != nil {
+			return err
+		}
+	}
+
+	addresses, err := zoneServerAddresses(ctx, server, mdns.Fqdn(parent))
+	if err != nil {
+		return fmt.Errorf("servers of parent zone %s: %w", parent, err)
+	}
+
+	err = errNoServerAddress
+	for _, address := range addresses {
+		var reply *reply
+		parentServer := Server{Address: address, EDNSBufferSize: defaultEDNSBufferSize, SourceAddress: server.SourceAddress}
+		reply, err = exchange(ctx, parentServer, Query{noRecursion: true}, fqdn, mdns.TypeDS)
+		if reply.msg == nil {
+			continue
+		}
+
+		result.ParentServer = address
+		result.Truncated = reply.truncated
+		result.Response = reply.msg
+		result.ResponseSize = reply.size
+		result.MinTTL, result.MaxTTL = answerTTLs(reply.msg)
+		if err != nil {
+			return err
+		}
+
+		for _, rr := range reply.msg.Answer {
+			if ds, ok := rr.(*mdns.DS); ok {
+				result.DS = append(result.DS, ds)
+			}
+		}
+		if len(result.DS) == 0 {
+			return noSuchHost(result)
+		}
+		return nil
+	}
+	return fmt.Errorf("lookup %s DS at parent zone %s: %w", result.FQDN, parent, err)
+}
+
+// findParentZone strips labels off fqdn until a name with its own SOA
+// record, i.e. a zone apex, is found
+func findParentZone(ctx context.Context, server Server, fqdn string) (string, error) {
+	labels := mdns.SplitDomainName(fqdn)
+	for i := 1; i <= len(labels); i++ {
+		candidate := mdns.Fqdn(strings.Join(labels[i:], "."))
+
+		reply, err := exchange(ctx, server, Query{}, candidate, mdns.TypeSOA)
+		var rcodeErr *rcodeError
+		if err != nil && !errors.As(err, &rcodeErr) {
+			return "", err
+		}
+		if reply.msg == nil {
+			continue
+		}
+		for _, rr := range reply.msg.Answer {
+			if soa, ok := rr.(*mdns.SOA); ok && strings.EqualFold(soa.Hdr.Name, candidate) {
+				return candidate, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no parent zone found for %s", fqdn)
+}
+
+// zoneServerAddresses returns the addresses of the name servers of zone as
+// resolved through server, IPv4 addresses first
+func zoneServerAddresses(ctx context.Context, server Server, zone string) ([]string, error) {
+	reply, err := exchange(ctx, server, Query{}, zone, mdns.TypeNS)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+	for _, rr := range reply.msg.Answer {
+		if ns, ok := rr.(*mdns.NS); ok {
+			hosts = append(hosts, ns.Ns)
+		}
+	}
+
+	var v4, v6 []string
+	for _, host := range hosts {
+		for _, qtype := range []uint16{mdns.TypeA, mdns.TypeAAAA} {
+			reply, err := exchange(ctx, server, Query{}, host, qtype)
+			if err != nil {
+				continue
+			}
+			for _, rr := range reply.msg.Answer {
+				switch rr := rr.(type) {
+				case *mdns.A:
+					v4 = append(v4, rr.A.String())
+				case *mdns.AAAA:
+					v6 = append(v6, rr.AAAA.String())
+				}
+			}
+		}
+	}
+	if len(v4)+len(v6) == 0 {
+		return nil, errNoServerAddress
+	}
+	return append(v4, v6...), nil
+}
@@ -0,0 +1,59 @@
+package dns
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// DefaultEWMAAlpha is the smoothing factor of the response time moving
+// average when the query does not set one
+const DefaultEWMAAlpha = 0.3
+
+// ewmaState is the moving average response time of a name, record type and
+// server
+type ewmaState struct {
+	labels  prometheus.Labels
+	seconds float64
+}
+
+// recordEWMA folds the response time of a successful lookup into its
+// moving average, the first lookup starts the average at its own time.
+// Failed lookups leave the average unchanged.
+func (r *Resolver) recordEWMA(result *Result, alpha float64) {
+	if !result.Success {
+		return
+	}
+	if alpha <= 0 || alpha > 1 {
+		alpha = DefaultEWMAAlpha
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := result.FQDN + "|" + result.RecordType + "|" + result.DNSServer
+	state, ok := r.ewma[key]
+	if !ok {
+		state = &ewmaState{
+			labels: prometheus.Labels{
+				"fqdn":        result.FQDN,
+				"record_type": result.RecordType,
+				"dns_server":  result.DNSServer,
+			},
+			seconds: result.Duration.Seconds(),
+		}
+		r.ewma[key] = state
+	} else {
+		state.seconds = alpha*result.Duration.Seconds() + (1-alpha)*state.seconds
+	}
+	r.metrics.ResponseTimeEWMA.With(state.labels).Set(state.seconds)
+}
+
+// forgetEWMA drops the averages matching all of match, after their series
+// were deleted by Resolver.Forget
+func (r *Resolver) forgetEWMA(match prometheus.Labels) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, state := range r.ewma {
+		if matchesLabels(state.labels, match) {
+			delete(r.ewma, key)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package dns
+
+import (
+	"context"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultHealthCheckQuery is the name whose SOA record is queried to check
+// that a server answers, the root zone which every recursive resolver serves
+const DefaultHealthCheckQuery = "."
+
+// CheckHealth queries the SOA record of the server's health check name and
+// reports the server as up when it answers NOERROR, independent of any
+// target. The duration is exposed whether or not the check succeeded.
+func (r *Resolver) CheckHealth(server Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	server.doqConns = r.doqConns
+	server.dohClients = r.dohClients
+
+	name := server.HealthCheckQuery
+	if name == "" {
+		name = DefaultHealthCheckQuery
+	}
+
+	labels := prometheus.Labels{
+		"dns_server": server.Label(),
+	}
+
+	r.scopes.touch(labels)
+
+	start := time.Now()
+	_, err := exchange(ctx, server, Query{}, mdns.Fqdn(name), mdns.TypeSOA)
+	r.metrics.HealthCheckDuration.With(labels).Set(time.Since(start).Seconds())
+	r.metrics.ServerUp.With(labels).Set(boolToFloat(err == nil))
+	return err
+}
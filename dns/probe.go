@@ -0,0 +1,73 @@
+package dns
+
+import (
+	"slices"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Probe performs count sequential lookups of query against server, each
+// counted and exposed like a single Lookup. With more than one lookup the
+// minimum, median and maximum response time of the burst and the share of
+// successful lookups and of timed out lookups are exposed as well. A timeout
+// does not end the burst early. The results are returned in order.
+func (r *Resolver) Probe(query Query, server Server, timeout time.Duration, count int) []*Result {
+	results := make([]*Result, 0, max(count, 1))
+	for range max(count, 1) {
+		results = append(results, r.Lookup(query, server, timeout))
+	}
+
+	labels := prometheus.Labels{
+		"fqdn":          query.FQDN,
+		"record_type":   query.RecordType,
+		"dns_server":    server.Label(),
+		"client_subnet": query.ClientSubnet,
+	}
+
+	lossLabels := prometheus.Labels{
+		"fqdn":        query.FQDN,
+		"record_type": query.RecordType,
+		"dns_server":  server.Label(),
+	}
+
+	// A single lookup is fully described by the regular metrics, series
+	// left from an earlier configuration with more lookups are removed
+	if len(results) == 1 {
+		r.metrics.ResponseTimeMin.Delete(labels)
+		r.metrics.ResponseTimeMedian.Delete(labels)
+		r.metrics.ResponseTimeMax.Delete(labels)
+		r.metrics.ProbeSuccessRatio.Delete(labels)
+		r.metrics.QueryLossRatio.Delete(lossLabels)
+		return results
+	}
+
+	durations := make([]time.Duration, 0, len(results))
+	successes, timeouts := 0, 0
+	for _, result := range results {
+		durations = append(durations, result.Duration)
+		if result.Success {
+			successes++
+		} else if errorClass(result) == ErrorClassTimeout {
+			timeouts++
+		}
+	}
+	slices.Sort(durations)
+
+	r.metrics.ResponseTimeMin.With(labels).Set(durations[0].Seconds())
+	r.metrics.ResponseTimeMedian.With(labels).Set(median(durations).Seconds())
+	r.metrics.ResponseTimeMax.With(labels).Set(durations[len(durations)-1].Seconds())
+	r.metrics.ProbeSuccessRatio.With(labels).Set(float64(successes) / float64(len(results)))
+	r.metrics.QueryLossRatio.With(lossLabels).Set(float64(timeouts) / float64(len(results)))
+	return results
+}
+
+// median returns the median of sorted durations, the mean of the two middle
+// ones for an even count
+func median(sorted []time.Duration) time.Duration {
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 1 {
+		return sorted[middle]
+	}
+	return (sorted[middle-1] + sorted[middle]) / 2
+}
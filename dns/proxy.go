@@ -0,0 +1,64 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	mdns "github.com/miekg/dns"
+	"golang.org/x/net/proxy"
+)
+
+// ErrProxy is returned when the connection through a server's proxy cannot
+// be established, as opposed to the DNS server itself failing
+var ErrProxy = errors.New("proxy connection failed")
+
+// dial connects to the server over the network of client, through the
+// server's SOCKS5 proxy if one is configured
+func (s Server) dial(ctx context.Context, client *mdns.Client) (*mdns.Conn, error) {
+	if s.ProxyURL == nil {
+		return client.DialContext(ctx, s.dialAddress())
+	}
+
+	conn, err := s.dialProxy(ctx, s.dialAddress())
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasSuffix(client.Net, "-tls") {
+		tlsConfig := &tls.Config{}
+		if client.TLSConfig != nil {
+			tlsConfig = client.TLSConfig.Clone()
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName, _, _ = net.SplitHostPort(s.dialAddress())
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+	return &mdns.Conn{Conn: conn, UDPSize: client.UDPSize}, nil
+}
+
+// dialProxy opens a TCP connection to address through the server's proxy
+func (s Server) dialProxy(ctx context.Context, address string) (net.Conn, error) {
+	dialer, err := proxy.FromURL(s.ProxyURL, s.dialer("tcp"))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrProxy, err)
+	}
+	contextDialer, ok := dialer.(proxy.ContextDialer)
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported proxy scheme %q", ErrProxy, s.ProxyURL.Scheme)
+	}
+	conn, err := contextDialer.DialContext(ctx, s.network("tcp"), address)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrProxy, err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,100 @@
+package dns
+
+import (
+	"slices"
+	"time"
+
+	mdns "github.com/miekg/dns"
+)
+
+// Query describes a single DNS lookup performed by the resolver
+type Query struct {
+	FQDN       string
+	RecordType string
+
+	// Name sent in the query when it differs from FQDN, which labels the
+	// metrics, e.g. the A-label of an internationalized name ("" = FQDN)
+	Name string
+
+	// Subnet sent in the EDNS Client Subnet option, in CIDR notation ("" = none)
+	ClientSubnet string
+
+	// Names queried in order until one exists, instead of Name, for
+	// relative names completed with search domains (nil = Name only)
+	SearchNames []string
+
+	// Query class (0 = IN)
+	Class uint16
+
+	// Maximum number of CNAMEs allowed in the answer chain (0 = unlimited)
+	MaxCNAMEDepth int
+
+	// Window the availability ratio is computed over (0 =
+	// DefaultAvailabilityWindow)
+	AvailabilityWindow time.Duration
+
+	// Smoothing factor of the response time moving average, the weight of
+	// the newest response time (0 = DefaultEWMAAlpha)
+	EWMAAlpha float64
+
+	// Maximum number of resolved addresses exported as series, the first
+	// ones in sorted order are kept (0 = unlimited)
+	MaxExportedIPs int
+
+	// Randomize the case of the query name (DNS 0x20) and verify the
+	// response preserves it
+	RandomizeCase bool
+
+	// Invert success: the lookup succeeds only when the server answers
+	// NXDOMAIN, and any resolved record is reported as unexpected
+	ExpectNXDomain bool
+
+	// Parent zone queried for DS records ("" = found by stripping labels
+	// until a SOA record is found)
+	ParentZone string
+
+	// Queries repeated after a failed one within the timeout, waiting
+	// RetryBackoff before the first retry and twice as long before each
+	// further one. Failures of the error classes in RetryOn are retried,
+	// timeouts and network errors when it is empty.
+	Retries      int
+	RetryBackoff time.Duration
+	RetryOn      []string
+
+	// Response time above which a successful lookup counts as an SLO
+	// breach (0 = no SLO)
+	LatencySLO time.Duration
+
+	// Trace ID of the probe, attached as exemplar to the response duration
+	// histograms ("" = no exemplar)
+	TraceID string
+
+	// COOKIE option to send, set by the resolver for servers with cookies enabled
+	cookie *mdns.EDNS0_COOKIE
+
+	// Clear the RD flag, used for the iterative queries of a trace
+	noRecursion bool
+}
+
+// retryable reports whether a failed attempt of the query is retried
+func (q Query) retryable(result *Result) bool {
+	if q.ExpectNXDomain && result.Rcode == mdns.RcodeNameError {
+		return false
+	}
+	retryOn := q.RetryOn
+	if len(retryOn) == 0 {
+		retryOn = DefaultRetryOn
+	}
+	return slices.Contains(retryOn, errorClass(result))
+}
+
+// DefaultRetryOn lists the error classes retried when Query.RetryOn is empty
+var DefaultRetryOn = []string{ErrorClassTimeout, ErrorClassNetwork}
+
+// name returns the name sent in the query
+func (q Query) name() string {
+	if q.Name != "" {
+		return q.Name
+	}
+	return q.FQDN
+}
@@ -2,115 +2,802 @@ package dns
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
 	"net"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	mdns "github.com/miekg/dns"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 // Result represents DNS resolution result
 type Result struct {
-	FQDN       string
-	RecordType string
-	DNSServer  string
-	IPs        []net.IPAddr
-	Duration   time.Duration
-	Success    bool
-	Error      error
+	FQDN         string
+	RecordType   string
+	DNSServer    string
+	ClientSubnet string
+	IPs          []net.IPAddr
+	MX           []*net.MX
+	TXT          []string
+	NS           []*net.NS
+	SOA          *mdns.SOA
+	PTR          []string
+	SRV          []*mdns.SRV
+	SVCB         []*mdns.SVCB
+	DS           []*mdns.DS
+	DNSKEY       []*mdns.DNSKEY
+	MinTTL       time.Duration
+	MaxTTL       time.Duration
+	Response     *mdns.Msg
+	ResponseSize int
+	Rcode        int
+	Truncated    bool
+	NSID         string
+	// Whether the response preserved the randomized query name case
+	CasePreserved bool
+	// Whether a target expected to return NXDOMAIN resolved
+	UnexpectedResolution bool
+	// Number of CNAMEs in the answer chain (A/AAAA only)
+	CNAMEChainLength int
+	// Address of the parent zone server that answered a DS query
+	ParentServer string
+	// Whether the NSID option was sent, NSID is only meaningful if so
+	NSIDRequested bool
+	// Trace ID of the probe, attached as exemplar to latency observations
+	TraceID string
+	// Maximum number of addresses exported as series (0 = unlimited)
+	MaxExportedIPs int
+	// Queries sent, more than one when failed ones were retried
+	Attempts int
+	// Search name that resolved, "" without search names
+	ResolvedName string
+	Duration     time.Duration
+	Success      bool
+	Error        error
+
+	// Name sent in the query, see Query.Name
+	name string
+}
+
+// RcodeNoResponse is the rcode reported when no DNS response was received,
+// e.g. on timeouts and network errors
+const RcodeNoResponse = -1
+
+// Metrics holds the Prometheus collectors updated by the resolver
+type Metrics struct {
+	ResponseTime              *prometheus.GaugeVec
+	ResponseDuration          *prometheus.HistogramVec
+	ResponseDurationQuantiles *prometheus.SummaryVec
+	ServerResponseDuration    *prometheus.HistogramVec
+	ResponseTimeMin           *prometheus.GaugeVec
+	ResponseTimeMedian        *prometheus.GaugeVec
+	ResponseTimeMax           *prometheus.GaugeVec
+	ProbeSuccessRatio         *prometheus.GaugeVec
+	QueryLossRatio            *prometheus.GaugeVec
+	LastSuccessTimestamp      *prometheus.GaugeVec
+	ConsecutiveFailures       *prometheus.GaugeVec
+	LastErrorInfo             *prometheus.GaugeVec
+	ResolvedIPChangesTotal    *prometheus.CounterVec
+	ResolvedIPLastChange      *prometheus.GaugeVec
+	ResolvedIPSetHash         *prometheus.GaugeVec
+	ResolutionSuccess         *prometheus.GaugeVec
+	ResolvedIpCount           *prometheus.GaugeVec
+	QueryTotal                *prometheus.CounterVec
+	QueryTimeoutsTotal        *prometheus.CounterVec
+	ServerUp                  *prometheus.GaugeVec
+	HealthCheckDuration       *prometheus.GaugeVec
+	QueriesInFlight           prometheus.Gauge
+	QueriesInFlightMax        prometheus.Gauge
+	AvailabilityRatio         *prometheus.GaugeVec
+	ResponseTimeEWMA          *prometheus.GaugeVec
+	SLOBreachesTotal          *prometheus.CounterVec
+	SLOThreshold              *prometheus.GaugeVec
+	ResolvedIpAddress         *prometheus.GaugeVec
+	ResolvedIPTruncated       *prometheus.GaugeVec
+	ResolvedRecordCount       *prometheus.GaugeVec
+	MXRecord                  *prometheus.GaugeVec
+	TXTRecordCount            *prometheus.GaugeVec
+	TXTRecordsHash            *prometheus.GaugeVec
+	NSRecord                  *prometheus.GaugeVec
+	SOASerial                 *prometheus.GaugeVec
+	SOARefresh                *prometheus.GaugeVec
+	SOARetry                  *prometheus.GaugeVec
+	SOAExpire                 *prometheus.GaugeVec
+	SOAMinimumTTL             *prometheus.GaugeVec
+	PTRRecord                 *prometheus.GaugeVec
+	SRVRecord                 *prometheus.GaugeVec
+	SRVRecordWeight           *prometheus.GaugeVec
+	RecordTTL                 *prometheus.GaugeVec
+	RecordTTLMax              *prometheus.GaugeVec
+	LastResponseRcode         *prometheus.GaugeVec
+	ResponseTruncated         *prometheus.GaugeVec
+	ResponseSize              *prometheus.GaugeVec
+	ResponseAuthoritative     *prometheus.GaugeVec
+	RecursionAvailable        *prometheus.GaugeVec
+	ResponseNSIDInfo          *prometheus.GaugeVec
+	ServerChaosInfo           *prometheus.GaugeVec
+	CNAMEChainLength          *prometheus.GaugeVec
+	HTTPSRecord               *prometheus.GaugeVec
+	HTTPSParam                *prometheus.GaugeVec
+	ServerCookieSupported     *prometheus.GaugeVec
+	CasePreserved             *prometheus.GaugeVec
+	CaseMismatchTotal         *prometheus.CounterVec
+	UnexpectedResolutionTotal *prometheus.CounterVec
+	AXFRSuccess               *prometheus.GaugeVec
+	AXFRDuration              *prometheus.GaugeVec
+	AXFRRecordCount           *prometheus.GaugeVec
+	AXFRSOASerial             *prometheus.GaugeVec
+	SOASerialLag              *prometheus.GaugeVec
+	TraceHopDuration          *prometheus.GaugeVec
+	TraceTotalDuration        *prometheus.GaugeVec
+	TraceHops                 *prometheus.GaugeVec
+	TraceSuccess              *prometheus.GaugeVec
+	TraceFailuresTotal        *prometheus.CounterVec
+	DelegationConsistent      *prometheus.GaugeVec
+	DelegationCheckSuccess    *prometheus.GaugeVec
+	DelegationMissingNSTotal  *prometheus.CounterVec
+	GlueMismatchTotal         *prometheus.CounterVec
+	WildcardPresent           *prometheus.GaugeVec
+	WildcardIP                *prometheus.GaugeVec
+	WildcardCheckSuccess      *prometheus.GaugeVec
+	DSRecord                  *prometheus.GaugeVec
+	DNSKEYRecord              *prometheus.GaugeVec
+	DNSKEYCount               *prometheus.GaugeVec
+	DualStack                 *prometheus.GaugeVec
+	QUICHandshakeFailures     *prometheus.CounterVec
+	QueryAttempts             *prometheus.GaugeVec
+	SearchResolvedInfo        *prometheus.GaugeVec
 }
 
 // Resolver handles DNS resolution with metrics
 type Resolver struct {
-	responseTime      *prometheus.GaugeVec
-	resolutionSuccess *prometheus.GaugeVec
-	resolvedIpCount   *prometheus.GaugeVec
-	queryTotal        *prometheus.CounterVec
-	resolvedIpAddress *prometheus.GaugeVec
+	metrics Metrics
+
+	// Per-record series that must be removed when they leave the answer
+	ipSeries         *seriesTracker
+	mxSeries         *seriesTracker
+	nsSeries         *seriesTracker
+	ptrSeries        *seriesTracker
+	srvSeries        *seriesTracker
+	srvWeightSeries  *seriesTracker
+	nsidSeries       *seriesTracker
+	chaosSeries      *seriesTracker
+	httpsSeries      *seriesTracker
+	httpsParamSeries *seriesTracker
+	traceSeries      *seriesTracker
+	wildcardSeries   *seriesTracker
+	dsSeries         *seriesTracker
+	dnskeySeries     *seriesTracker
+	lastErrorSeries  *seriesTracker
+
+	// DNS cookies per server, kept across cycles
+	cookies *cookieJar
+
+	// Last update of the series of each probe, for ExpireStale
+	scopes *probeScopes
+
+	// DoQ connections, reused across cycles
+	doqConns *doqPool
+
+	// DoH clients, whose connections are reused across cycles
+	dohClients *dohPool
+
+	// Per-cycle state, aggregated by EndCycle
+	mu                 sync.Mutex
+	recursionAvailable map[string]bool
+	soaSerials         map[string]map[string]uint32
+	dualStack          map[string]*dualStackState
+	cycleFailures      map[string]*failureState
+
+	// Lookups in progress and the most at once during the cycle
+	inFlight    int
+	inFlightMax int
+
+	// Consecutive failed cycles per fqdn, record type and server, kept
+	// across cycles
+	consecutiveFailures map[string]*failureState
+
+	// Lookup outcomes within the availability window per fqdn, record
+	// type and server, kept across cycles
+	availability map[string]*availabilityWindow
+
+	// Moving average response time per fqdn, record type and server, kept
+	// across cycles
+	ewma map[string]*ewmaState
+
+	// Last resolved address set per fqdn, record type, server and client
+	// subnet, as sorted addresses joined by commas
+	ipSets map[string]string
+}
+
+// failureState tracks the failures of the lookups of a name, record type
+// and server
+type failureState struct {
+	labels prometheus.Labels
+	// Whether a lookup failed during the cycle (cycleFailures), or the
+	// number of consecutive failed cycles (consecutiveFailures)
+	failed bool
+	count  int
+}
+
+// dualStackState collects the A and AAAA outcomes for a name and server
+// during a cycle
+type dualStackState struct {
+	fqdn      string
+	dnsServer string
+	// Whether the family was queried, and whether any lookup of it failed
+	// or returned no address (client subnet variants are queried separately)
+	queriedA, queriedAAAA bool
+	failedA, failedAAAA   bool
 }
 
 // NewResolver creates a new DNS resolver with metrics
-func NewResolver(responseTime, resolutionSuccess, resolvedIpCount *prometheus.GaugeVec,
-	queryTotal *prometheus.CounterVec, resolvedIpAddress *prometheus.GaugeVec) *Resolver {
+func NewResolver(metrics Metrics) *Resolver {
 	return &Resolver{
-		responseTime:      responseTime,
-		resolutionSuccess: resolutionSuccess,
-		resolvedIpCount:   resolvedIpCount,
-		queryTotal:        queryTotal,
-		resolvedIpAddress: resolvedIpAddress,
+		metrics:          metrics,
+		ipSeries:         newSeriesTracker(metrics.ResolvedIpAddress),
+		mxSeries:         newSeriesTracker(metrics.MXRecord),
+		nsSeries:         newSeriesTracker(metrics.NSRecord),
+		ptrSeries:        newSeriesTracker(metrics.PTRRecord),
+		srvSeries:        newSeriesTracker(metrics.SRVRecord),
+		srvWeightSeries:  newSeriesTracker(metrics.SRVRecordWeight),
+		nsidSeries:       newSeriesTracker(metrics.ResponseNSIDInfo),
+		chaosSeries:      newSeriesTracker(metrics.ServerChaosInfo),
+		httpsSeries:      newSeriesTracker(metrics.HTTPSRecord),
+		httpsParamSeries: newSeriesTracker(metrics.HTTPSParam),
+		traceSeries:      newSeriesTracker(metrics.TraceHopDuration),
+		wildcardSeries:   newSeriesTracker(metrics.WildcardIP),
+		dsSeries:         newSeriesTracker(metrics.DSRecord),
+		dnskeySeries:     newSeriesTracker(metrics.DNSKEYRecord),
+		lastErrorSeries:  newSeriesTracker(metrics.LastErrorInfo),
+
+		cookies:    newCookieJar(),
+		scopes:     newProbeScopes(),
+		doqConns:   newDoQPool(),
+		dohClients: newDoHPool(),
+
+		recursionAvailable: make(map[string]bool),
+		soaSerials:         make(map[string]map[string]uint32),
+		dualStack:          make(map[string]*dualStackState),
+		cycleFailures:      make(map[string]*failureState),
+		availability:       make(map[string]*availabilityWindow),
+		ewma:               make(map[string]*ewmaState),
+
+		consecutiveFailures: make(map[string]*failureState),
+		ipSets:              make(map[string]string),
 	}
 }
 
-// Lookup performs DNS resolution and updates metrics
-func (r *Resolver) Lookup(fqdn, dnsServer, recordType string, timeout time.Duration) *Result {
-	start := time.Now()
+// ResetConnections drops the DoH clients and DoQ connections kept across
+// cycles, so servers are connected to with the TLS, proxy and transport
+// settings of a reloaded configuration. Connections of lookups still
+// running stay open until the lookups are done, or for DoH until the
+// server closes them.
+func (r *Resolver) ResetConnections() {
+	r.dohClients.reset()
+}
 
-	// Create resolver with custom DNS server if specified
-	resolver := &net.Resolver{
-		PreferGo: true,
-		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-			d := net.Dialer{
-				Timeout: time.Second * 5,
-			}
-			if dnsServer != "" {
-				// Handle IPv6 addresses by wrapping them in brackets
-				if strings.Contains(dnsServer, ":") && !strings.HasPrefix(dnsServer, "[") {
-					dnsServer = "[" + dnsServer + "]"
-				}
-				return d.DialContext(ctx, network, dnsServer+":53")
+// EndCycle publishes the metrics aggregated over all lookups performed since
+// the previous call. It is called once per monitoring cycle.
+func (r *Resolver) EndCycle() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Recursion is only considered available when every response from the
+	// server during the cycle had the RA flag set
+	for dnsServer, available := range r.recursionAvailable {
+		r.metrics.RecursionAvailable.With(prometheus.Labels{
+			"dns_server": dnsServer,
+		}).Set(boolToFloat(available))
+	}
+	r.recursionAvailable = make(map[string]bool)
+
+	// Lag of each server behind the highest SOA serial seen for the zone
+	for fqdn, serials := range r.soaSerials {
+		var highest uint32
+		first := true
+		for _, serial := range serials {
+			if first || serialGreater(serial, highest) {
+				highest = serial
+				first = false
 			}
-			return d.DialContext(ctx, network, address)
-		},
+		}
+		for dnsServer, serial := range serials {
+			r.metrics.SOASerialLag.With(prometheus.Labels{
+				"fqdn":       fqdn,
+				"dns_server": dnsServer,
+			}).Set(float64(highest - serial))
+		}
+	}
+	r.soaSerials = make(map[string]map[string]uint32)
+
+	// Dual-stack only for names queried for both families in this cycle
+	for _, state := range r.dualStack {
+		if !state.queriedA || !state.queriedAAAA {
+			continue
+		}
+		r.metrics.DualStack.With(prometheus.Labels{
+			"fqdn":       state.fqdn,
+			"dns_server": state.dnsServer,
+		}).Set(boolToFloat(!state.failedA && !state.failedAAAA))
+	}
+	r.dualStack = make(map[string]*dualStackState)
+
+	// A cycle counts as failed when any lookup of the key failed, e.g. one
+	// of its client subnet variants. Keys not looked up during the cycle
+	// keep their count, as targets with a longer interval are not probed
+	// every cycle; removed targets and servers are dropped by Forget.
+	for key, cycle := range r.cycleFailures {
+		state, ok := r.consecutiveFailures[key]
+		if !ok {
+			state = &failureState{labels: cycle.labels}
+			r.consecutiveFailures[key] = state
+		}
+		if cycle.failed {
+			state.count++
+		} else {
+			state.count = 0
+		}
+		r.metrics.ConsecutiveFailures.With(state.labels).Set(float64(state.count))
+	}
+	r.cycleFailures = make(map[string]*failureState)
+
+	// Lookups still in progress count towards the next cycle's high-water
+	// mark
+	r.metrics.QueriesInFlightMax.Set(float64(r.inFlightMax))
+	r.inFlightMax = r.inFlight
+}
+
+// serialGreater compares SOA serials using serial number arithmetic (RFC 1982)
+func serialGreater(a, b uint32) bool {
+	return a != b && int32(a-b) > 0
+}
+
+// recordSOASerial remembers the SOA serial a server returned for EndCycle
+func (r *Resolver) recordSOASerial(fqdn, dnsServer string, serial uint32) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.soaSerials[fqdn] == nil {
+		r.soaSerials[fqdn] = make(map[string]uint32)
+	}
+	r.soaSerials[fqdn][dnsServer] = serial
+}
+
+// recordDualStack accumulates the outcome of an A or AAAA lookup for EndCycle
+func (r *Resolver) recordDualStack(result *Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := result.FQDN + "|" + result.DNSServer
+	state, ok := r.dualStack[key]
+	if !ok {
+		state = &dualStackState{fqdn: result.FQDN, dnsServer: result.DNSServer}
+		r.dualStack[key] = state
+	}
+
+	failed := !result.Success || len(result.IPs) == 0
+	if result.RecordType == "A" {
+		state.queriedA = true
+		state.failedA = state.failedA || failed
+	} else {
+		state.queriedAAAA = true
+		state.failedAAAA = state.failedAAAA || failed
+	}
+}
+
+// recordFailure accumulates the outcome of a lookup for EndCycle
+func (r *Resolver) recordFailure(result *Result) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := result.FQDN + "|" + result.RecordType + "|" + result.DNSServer
+	state, ok := r.cycleFailures[key]
+	if !ok {
+		state = &failureState{labels: prometheus.Labels{
+			"fqdn":        result.FQDN,
+			"record_type": result.RecordType,
+			"dns_server":  result.DNSServer,
+		}}
+		r.cycleFailures[key] = state
+	}
+	state.failed = state.failed || !result.Success
+}
+
+// forgetFailures drops the failure counts matching all of match, after
+// their series were deleted by Resolver.Forget
+func (r *Resolver) forgetFailures(match prometheus.Labels) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, state := range r.consecutiveFailures {
+		if matchesLabels(state.labels, match) {
+			delete(r.consecutiveFailures, key)
+		}
+	}
+}
+
+// recordSLO counts a successful lookup slower than slo as a breach of the
+// latency SLO and exposes the threshold. Failed lookups are not breaches.
+func (r *Resolver) recordSLO(result *Result, slo time.Duration) {
+	labels := prometheus.Labels{
+		"fqdn":        result.FQDN,
+		"record_type": result.RecordType,
+		"dns_server":  result.DNSServer,
+	}
+	if slo <= 0 {
+		r.metrics.SLOThreshold.Delete(labels)
+		return
+	}
+
+	r.metrics.SLOThreshold.With(labels).Set(slo.Seconds())
+	breaches := r.metrics.SLOBreachesTotal.With(labels)
+	if result.Success && result.Duration > slo {
+		breaches.Inc()
+	}
+}
+
+// startLookup counts a lookup as in flight until the returned function is
+// called
+func (r *Resolver) startLookup() func() {
+	r.mu.Lock()
+	r.inFlight++
+	r.inFlightMax = max(r.inFlightMax, r.inFlight)
+	r.mu.Unlock()
+	r.metrics.QueriesInFlight.Inc()
+
+	return func() {
+		r.mu.Lock()
+		r.inFlight--
+		r.mu.Unlock()
+		r.metrics.QueriesInFlight.Dec()
 	}
+}
+
+// recordRecursionAvailable accumulates the RA flag of a response for EndCycle
+func (r *Resolver) recordRecursionAvailable(dnsServer string, available bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if seen, ok := r.recursionAvailable[dnsServer]; ok {
+		available = available && seen
+	}
+	r.recursionAvailable[dnsServer] = available
+}
+
+// rawRecordTypes lists the record types queried with the raw client
+var rawRecordTypes = []string{"A", "AAAA", "MX", "TXT", "NS", "SOA", "PTR", "SRV", "HTTPS", "SVCB", "DNSKEY"}
+
+// Lookup performs DNS resolution and updates metrics
+func (r *Resolver) Lookup(query Query, server Server, timeout time.Duration) *Result {
+	defer r.startLookup()()
+	start := time.Now()
 
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	var ips []net.IPAddr
+	server.doqConns = r.doqConns
+	server.dohClients = r.dohClients
+
+	// Every attempt gets an equal share of the timeout, so retries fit in it
+	var result *Result
 	var err error
+	attemptTimeout := timeout / time.Duration(max(query.Retries, 0)+1)
+	backoff := query.RetryBackoff
+	for attempt := 1; ; attempt++ {
+		result = &Result{
+			FQDN:           query.FQDN,
+			name:           query.name(),
+			RecordType:     query.RecordType,
+			DNSServer:      server.Label(),
+			ClientSubnet:   query.ClientSubnet,
+			MaxExportedIPs: query.MaxExportedIPs,
+			TraceID:        query.TraceID,
+			Attempts:       attempt,
+		}
+		attemptCtx, cancelAttempt := context.WithTimeout(ctx, attemptTimeout)
+		err = r.lookupOnce(attemptCtx, query, server, result)
+		cancelAttempt()
+		if errors.Is(err, ErrQUICHandshake) {
+			r.metrics.QUICHandshakeFailures.With(prometheus.Labels{
+				"dns_server": result.DNSServer,
+			}).Inc()
+		}
 
-	switch recordType {
-	case "A":
-		// IPv4 only
-		ipv4s, lookupErr := resolver.LookupIP(ctx, "ip4", fqdn)
-		if lookupErr == nil {
-			for _, ip := range ipv4s {
-				ips = append(ips, net.IPAddr{IP: ip})
-			}
+		if result.Response != nil {
+			result.Rcode = result.Response.Rcode
+		} else {
+			result.Rcode = stdlibRcode(err)
 		}
-		err = lookupErr
-	case "AAAA":
-		// IPv6 only
-		ipv6s, lookupErr := resolver.LookupIP(ctx, "ip6", fqdn)
-		if lookupErr == nil {
-			for _, ip := range ipv6s {
-				ips = append(ips, net.IPAddr{IP: ip})
-			}
+		result.Error = err
+		if err == nil || attempt > query.Retries || !query.retryable(result) {
+			break
+		}
+
+		// Not retried when the backoff would not leave time for an attempt
+		if deadline, _ := ctx.Deadline(); time.Now().Add(backoff).After(deadline) {
+			break
+		}
+		if backoff > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
 		}
-		err = lookupErr
-	default:
-		// Both IPv4 and IPv6
-		ips, err = resolver.LookupIPAddr(ctx, fqdn)
 	}
 
-	duration := time.Since(start)
+	if query.ExpectNXDomain {
+		err = expectNXDomain(result, err)
+	}
+
+	result.Duration = time.Since(start)
+	result.Success = err == nil
+	result.Error = err
 
-	result := &Result{
-		FQDN:       fqdn,
-		RecordType: recordType,
-		DNSServer:  dnsServer,
-		IPs:        ips,
-		Duration:   duration,
-		Success:    err == nil,
-		Error:      err,
+	if (query.RecordType == "A" || query.RecordType == "AAAA") && !query.ExpectNXDomain {
+		r.recordDualStack(result)
 	}
 
+	r.recordFailure(result)
+	r.scopes.touch(
+		prometheus.Labels{"fqdn": result.FQDN, "record_type": result.RecordType, "dns_server": result.DNSServer},
+		prometheus.Labels{"fqdn": result.FQDN, "dns_server": result.DNSServer},
+		prometheus.Labels{"record_type": result.RecordType, "dns_server": result.DNSServer},
+		prometheus.Labels{"dns_server": result.DNSServer},
+	)
+	r.recordAvailability(result, query.AvailabilityWindow)
+	r.recordEWMA(result, query.EWMAAlpha)
+	r.recordSLO(result, query.LatencySLO)
+
 	// Update metrics
 	r.updateMetrics(result)
 
 	return result
 }
 
+// lookupOnce sends a single query of a lookup, filling in result. With
+// search names, they are queried in order until one exists.
+func (r *Resolver) lookupOnce(ctx context.Context, query Query, server Server, result *Result) error {
+	if len(query.SearchNames) == 0 {
+		return r.lookupName(ctx, query, server, result)
+	}
+
+	base := *result
+	var err error
+	for _, name := range query.SearchNames {
+		*result = base
+		result.name = name
+		query.Name = name
+		err = r.lookupName(ctx, query, server, result)
+		if err == nil {
+			result.ResolvedName = name
+			return nil
+		}
+
+		// Like a stub resolver, the next name is only tried when this one
+		// does not exist or has no records of the type
+		rcode := stdlibRcode(err)
+		if result.Response != nil {
+			rcode = result.Response.Rcode
+		}
+		if rcode != mdns.RcodeNameError && rcode != mdns.RcodeSuccess {
+			break
+		}
+	}
+	return err
+}
+
+// lookupName sends a single query for result.name, filling in result
+func (r *Resolver) lookupName(ctx context.Context, query Query, server Server, result *Result) error {
+	// Only queries sent by the raw client carry a class
+	if query.Class > mdns.ClassINET && (server.System() || !slices.Contains(rawRecordTypes, query.RecordType)) {
+		return fmt.Errorf("%s queries in class %s are not supported by %s", query.RecordType, mdns.ClassToString[query.Class], server.Label())
+	}
+
+	var err error
+	switch {
+	case server.System():
+		// Goes through the host's resolver configuration like any other
+		// program on it would
+		err = lookupSystem(ctx, result)
+	case slices.Contains(rawRecordTypes, query.RecordType):
+		// Query the server directly so the raw response (TTLs, rcode etc.) is available
+		if server.Cookies {
+			query.cookie = r.cookies.option(server.Label())
+		}
+		err = lookupRaw(ctx, server, query, result)
+		result.NSIDRequested = server.NSID
+
+		if query.RandomizeCase && result.Response != nil {
+			r.updateCaseMetrics(result)
+		}
+
+		if server.Cookies && result.Response != nil {
+			supported := r.cookies.update(server.Label(), result.Response)
+			r.metrics.ServerCookieSupported.With(prometheus.Labels{
+				"dns_server": server.Label(),
+			}).Set(boolToFloat(supported))
+		}
+	case query.RecordType == "DS":
+		// Answered by the parent zone's servers rather than server
+		err = lookupDS(ctx, server, query, result)
+	default:
+		if server.Protocol != "" && server.Protocol != ProtocolDo53 {
+			err = fmt.Errorf("record type %s is not supported over %s", query.RecordType, server.Protocol)
+			break
+		}
+		err = lookupStdlib(ctx, server, result)
+	}
+	return err
+}
+
+// lookupStdlib resolves the record types handled by net.Resolver
+func lookupStdlib(ctx context.Context, server Server, result *Result) error {
+	// Create resolver with custom DNS server if specified
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			d := server.dialer(network)
+			d.Timeout = time.Second * 5
+			if server.Address != "" {
+				return d.DialContext(ctx, server.network(network), server.dialAddress())
+			}
+			return d.DialContext(ctx, network, address)
+		},
+	}
+
+	// Both IPv4 and IPv6
+	var err error
+	result.IPs, err = resolver.LookupIPAddr(ctx, result.name)
+	return err
+}
+
+// expectNXDomain inverts the outcome of a lookup for targets that must not
+// resolve. NXDOMAIN is a success, a successful resolution is a failure and
+// every other error (timeouts, SERVFAIL, ...) stays a failure.
+func expectNXDomain(result *Result, err error) error {
+	if result.Rcode == mdns.RcodeNameError {
+		return nil
+	}
+	if err == nil {
+		result.UnexpectedResolution = true
+		return fmt.Errorf("lookup %s on %s: %w", result.FQDN, result.DNSServer, ErrUnexpectedResolution)
+	}
+	return err
+}
+
+// stdlibRcode derives the rcode of a lookup that did not go through the raw
+// client. net.Resolver only tells us about NXDOMAIN and SERVFAIL; anything
+// else without an error is NOERROR and every other error is treated as no
+// response.
+func stdlibRcode(err error) int {
+	if err == nil {
+		return mdns.RcodeSuccess
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return mdns.RcodeNameError
+	}
+	// How net.Resolver reports SERVFAIL responses
+	if dnsErr != nil && dnsErr.Err == "server misbehaving" {
+		return mdns.RcodeServerFailure
+	}
+	return RcodeNoResponse
+}
+
+// boolToFloat converts a flag to a gauge value
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Values of the error_class label of failed lookups. Successful lookups
+// are labeled "none".
+const (
+	ErrorClassTimeout  = "timeout"
+	ErrorClassNXDomain = "nxdomain"
+	ErrorClassServFail = "servfail"
+	ErrorClassRefused  = "refused"
+	ErrorClassNetwork  = "network"
+	ErrorClassProxy    = "proxy"
+	ErrorClassTLS      = "tls"
+	ErrorClassCNAME    = "cname"
+	ErrorClassOther    = "other"
+)
+
+// errorClass returns the error_class label value for a failed lookup. The
+// path to the server is checked first (proxy, TLS, timeouts, connection
+// errors), then the rcode of the response and the CNAME chain of the
+// answer, and anything else, such as an empty answer, is "other".
+func errorClass(result *Result) string {
+	err := result.Error
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	switch {
+	case errors.Is(err, ErrProxy):
+		return ErrorClassProxy
+	case isTLSError(err):
+		return ErrorClassTLS
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout(),
+		errors.As(err, &dnsErr) && dnsErr.IsTimeout:
+		return ErrorClassTimeout
+	}
+
+	switch result.Rcode {
+	case mdns.RcodeNameError:
+		return ErrorClassNXDomain
+	case mdns.RcodeServerFailure:
+		return ErrorClassServFail
+	case mdns.RcodeRefused:
+		return ErrorClassRefused
+	}
+
+	switch {
+	case errors.Is(err, ErrCNAMEDepthExceeded), errors.Is(err, ErrCNAMELoop):
+		return ErrorClassCNAME
+	case errors.Is(err, ErrQUICHandshake),
+		errors.As(err, &opErr),
+		errors.As(err, &dnsErr),
+		errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrorClassNetwork
+	default:
+		return ErrorClassOther
+	}
+}
+
+// isTLSError reports whether err is a failed TLS handshake, e.g. an expired
+// or untrusted server certificate or a client certificate the server
+// rejected
+func isTLSError(err error) bool {
+	var verificationErr *tls.CertificateVerificationError
+	var alertErr tls.AlertError
+	var recordErr tls.RecordHeaderError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	return errors.As(err, &verificationErr) ||
+		errors.As(err, &alertErr) ||
+		errors.As(err, &recordErr) ||
+		errors.As(err, &unknownAuthorityErr) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &invalidErr)
+}
+
+// rcodeLabel returns the rcode label value for a result
+func rcodeLabel(result *Result) string {
+	if result.Rcode == RcodeNoResponse {
+		return "none"
+	}
+	if name, ok := mdns.RcodeToString[result.Rcode]; ok {
+		return name
+	}
+	return strconv.Itoa(result.Rcode)
+}
+
+// observe records value on observer, with a trace_id exemplar when the
+// lookup has a trace ID. Without one no exemplar labels are allocated.
+func observe(observer prometheus.Observer, value float64, traceID string) {
+	exemplarObserver, ok := observer.(prometheus.ExemplarObserver)
+	if traceID == "" || !ok {
+		observer.Observe(value)
+		return
+	}
+	exemplarObserver.ObserveWithExemplar(value, prometheus.Labels{"trace_id": traceID})
+}
+
 // updateMetrics updates Prometheus metrics based on DNS resolution result
 func (r *Resolver) updateMetrics(result *Result) {
 	labels := prometheus.Labels{
@@ -118,40 +805,448 @@ func (r *Resolver) updateMetrics(result *Result) {
 		"record_type": result.RecordType,
 		"dns_server":  result.DNSServer,
 	}
+	subnetLabels := prometheus.Labels{
+		"fqdn":          result.FQDN,
+		"record_type":   result.RecordType,
+		"dns_server":    result.DNSServer,
+		"client_subnet": result.ClientSubnet,
+	}
+
+	// Update response time, the per-fqdn metrics that are not exported are
+	// left out
+	if r.metrics.ResponseTime != nil {
+		r.metrics.ResponseTime.With(subnetLabels).Set(result.Duration.Seconds())
+	}
+	if r.metrics.ResponseDuration != nil {
+		observe(r.metrics.ResponseDuration.With(subnetLabels), result.Duration.Seconds(), result.TraceID)
+	}
+	if r.metrics.ResponseDurationQuantiles != nil {
+		r.metrics.ResponseDurationQuantiles.With(labels).Observe(result.Duration.Seconds())
+	}
+	observe(r.metrics.ServerResponseDuration.With(prometheus.Labels{
+		"dns_server":  result.DNSServer,
+		"record_type": result.RecordType,
+	}), result.Duration.Seconds(), result.TraceID)
+
+	// TTLs, header flags and the response size are only known when the server was queried directly
+	if result.Response != nil {
+		r.metrics.ResponseSize.With(labels).Set(float64(result.ResponseSize))
+		r.metrics.ResponseAuthoritative.With(labels).Set(boolToFloat(result.Response.Authoritative))
+		// DS answers come from the parent zone's servers, which don't recurse
+		if result.ParentServer == "" {
+			r.recordRecursionAvailable(result.DNSServer, result.Response.RecursionAvailable)
+		}
+		if result.NSIDRequested {
+			r.updateNSIDMetrics(result)
+		}
+		if result.RecordType == "A" || result.RecordType == "AAAA" {
+			r.metrics.CNAMEChainLength.With(labels).Set(float64(result.CNAMEChainLength))
+		}
+		if len(result.Response.Answer) > 0 {
+			r.metrics.RecordTTL.With(labels).Set(result.MinTTL.Seconds())
+			r.metrics.RecordTTLMax.With(labels).Set(result.MaxTTL.Seconds())
+		}
+	}
 
-	// Update response time
-	r.responseTime.With(labels).Set(result.Duration.Seconds())
+	r.metrics.LastResponseRcode.With(labels).Set(float64(result.Rcode))
+	r.metrics.QueryAttempts.With(labels).Set(float64(result.Attempts))
+	r.metrics.SearchResolvedInfo.DeletePartialMatch(labels)
+	if result.ResolvedName != "" {
+		r.metrics.SearchResolvedInfo.With(prometheus.Labels{
+			"fqdn":          result.FQDN,
+			"record_type":   result.RecordType,
+			"dns_server":    result.DNSServer,
+			"resolved_fqdn": result.ResolvedName,
+		}).Set(1)
+	}
+
+	// Created on every lookup so the timeout rate is 0 rather than absent
+	// while a server answers
+	timeouts := r.metrics.QueryTimeoutsTotal.With(labels)
+
+	// The TC flag is only known when the server was queried directly
+	if result.Response != nil || result.Truncated {
+		r.metrics.ResponseTruncated.With(labels).Set(boolToFloat(result.Truncated))
+	}
 
 	if !result.Success {
 		// DNS resolution failed
-		r.resolutionSuccess.With(labels).Set(0)
-		r.queryTotal.With(prometheus.Labels{
+		class := errorClass(result)
+		r.metrics.ResolutionSuccess.With(subnetLabels).Set(0)
+		r.metrics.QueryTotal.With(prometheus.Labels{
 			"fqdn":        result.FQDN,
 			"record_type": result.RecordType,
 			"dns_server":  result.DNSServer,
 			"status":      "failure",
+			"rcode":       rcodeLabel(result),
+			"error_class": class,
 		}).Inc()
+		// Timeouts are classified before network errors, so a timed out
+		// query is never also counted as a network failure
+		if class == ErrorClassTimeout {
+			timeouts.Inc()
+		}
+
+		errorLabels := prometheus.Labels{
+			"fqdn":        result.FQDN,
+			"record_type": result.RecordType,
+			"dns_server":  result.DNSServer,
+			"error_class": class,
+		}
+		r.metrics.LastErrorInfo.With(errorLabels).Set(1)
+		r.lastErrorSeries.replace(seriesKey(result), []prometheus.Labels{errorLabels})
+
+		// Export what a name that must not resolve resolved to
+		if result.UnexpectedResolution {
+			r.metrics.UnexpectedResolutionTotal.With(labels).Inc()
+			r.updateIPMetrics(result, subnetLabels)
+		}
 		return
 	}
 
 	// DNS resolution succeeded
-	r.resolutionSuccess.With(labels).Set(1)
-	r.resolvedIpCount.With(labels).Set(float64(len(result.IPs)))
-	r.queryTotal.With(prometheus.Labels{
+	r.metrics.ResolutionSuccess.With(subnetLabels).Set(1)
+	r.lastErrorSeries.replace(seriesKey(result), nil)
+	// Only set on success, so it keeps the time of the last success while
+	// lookups fail
+	r.metrics.LastSuccessTimestamp.With(labels).SetToCurrentTime()
+	r.metrics.QueryTotal.With(prometheus.Labels{
 		"fqdn":        result.FQDN,
 		"record_type": result.RecordType,
 		"dns_server":  result.DNSServer,
 		"status":      "success",
+		"rcode":       rcodeLabel(result),
+		"error_class": "none",
 	}).Inc()
 
-	// Set metrics for each resolved IP
-	for _, ip := range result.IPs {
+	switch result.RecordType {
+	case "MX":
+		r.updateMXMetrics(result, labels)
+		return
+	case "TXT":
+		r.updateTXTMetrics(result)
+		return
+	case "NS":
+		r.updateNSMetrics(result, labels)
+		return
+	case "SOA":
+		r.updateSOAMetrics(result)
+		return
+	case "PTR":
+		r.updatePTRMetrics(result, labels)
+		return
+	case "SRV":
+		r.updateSRVMetrics(result, labels)
+		return
+	case "HTTPS", "SVCB":
+		r.updateSVCBMetrics(result, labels)
+		return
+	case "DS":
+		r.updateDSMetrics(result, labels)
+		return
+	case "DNSKEY":
+		r.updateDNSKEYMetrics(result)
+		return
+	}
+
+	r.updateIPMetrics(result, subnetLabels)
+	r.updateIPChangeMetrics(result)
+}
+
+// updateIPChangeMetrics exposes a hash of the resolved address set and
+// counts its changes between successful lookups. The first lookup sets the
+// change time without counting a change.
+func (r *Resolver) updateIPChangeMetrics(result *Result) {
+	ips := sortedIPs(result.IPs)
+	set := strings.Join(ips, ",")
+	key := seriesKey(result) + "|" + result.ClientSubnet
+
+	r.mu.Lock()
+	previous, seen := r.ipSets[key]
+	r.ipSets[key] = set
+	r.mu.Unlock()
+
+	labels := prometheus.Labels{
+		"fqdn":        result.FQDN,
+		"record_type": result.RecordType,
+		"dns_server":  result.DNSServer,
+	}
+	// Equal sets hash equal on every server, so differing answers show up
+	// as differing hashes across dns_server
+	r.metrics.ResolvedIPSetHash.With(labels).Set(float64(hashStrings(ips)))
+
+	changes := r.metrics.ResolvedIPChangesTotal.With(labels)
+	if seen && previous == set {
+		return
+	}
+	if seen {
+		changes.Inc()
+	}
+	r.metrics.ResolvedIPLastChange.With(labels).SetToCurrentTime()
+}
+
+// sortedIPs returns the addresses in their canonical text form, sorted.
+// IPv4-mapped IPv6 addresses are written as IPv4 addresses.
+func sortedIPs(ips []net.IPAddr) []string {
+	sorted := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		if ip4 := ip.IP.To4(); ip4 != nil {
+			sorted = append(sorted, ip4.String())
+		} else {
+			sorted = append(sorted, ip.IP.String())
+		}
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// updateIPMetrics exposes the addresses of an address answer. The count
+// always reports every address, while only the first MaxExportedIPs of them
+// in sorted order get their own series.
+func (r *Resolver) updateIPMetrics(result *Result, labels prometheus.Labels) {
+	r.metrics.ResolvedIpCount.With(labels).Set(float64(len(result.IPs)))
+
+	ips := sortedIPs(result.IPs)
+	truncated := result.MaxExportedIPs > 0 && len(ips) > result.MaxExportedIPs
+	if truncated {
+		ips = ips[:result.MaxExportedIPs]
+	}
+	r.metrics.ResolvedIPTruncated.With(labels).Set(boolToFloat(truncated))
+
+	// Set metrics for each resolved IP, addresses that left the answer are
+	// removed
+	series := make([]prometheus.Labels, 0, len(ips))
+	for _, ip := range ips {
 		ipLabels := prometheus.Labels{
+			"fqdn":          result.FQDN,
+			"record_type":   result.RecordType,
+			"dns_server":    result.DNSServer,
+			"client_subnet": result.ClientSubnet,
+			"ip_address":    ip,
+		}
+		r.metrics.ResolvedIpAddress.With(ipLabels).Set(1)
+		series = append(series, ipLabels)
+	}
+	r.ipSeries.replace(seriesKey(result)+"|"+result.ClientSubnet, series)
+}
+
+// updateMXMetrics exposes the exchanges and preferences of an MX answer
+func (r *Resolver) updateMXMetrics(result *Result, labels prometheus.Labels) {
+	r.metrics.ResolvedRecordCount.With(labels).Set(float64(len(result.MX)))
+
+	series := make([]prometheus.Labels, 0, len(result.MX))
+	for _, mx := range result.MX {
+		mxLabels := prometheus.Labels{
+			"fqdn":       result.FQDN,
+			"dns_server": result.DNSServer,
+			"exchange":   mx.Host,
+		}
+		r.metrics.MXRecord.With(mxLabels).Set(float64(mx.Pref))
+		series = append(series, mxLabels)
+	}
+	r.mxSeries.replace(seriesKey(result), series)
+}
+
+// updateNSMetrics exposes the nameservers of an NS answer
+func (r *Resolver) updateNSMetrics(result *Result, labels prometheus.Labels) {
+	r.metrics.ResolvedRecordCount.With(labels).Set(float64(len(result.NS)))
+
+	series := make([]prometheus.Labels, 0, len(result.NS))
+	for _, ns := range result.NS {
+		nsLabels := prometheus.Labels{
+			"fqdn":       result.FQDN,
+			"dns_server": result.DNSServer,
+			"nameserver": ns.Host,
+		}
+		r.metrics.NSRecord.With(nsLabels).Set(1)
+		series = append(series, nsLabels)
+	}
+	r.nsSeries.replace(seriesKey(result), series)
+}
+
+// updateSOAMetrics exposes the serial and timers of an SOA answer
+func (r *Resolver) updateSOAMetrics(result *Result) {
+	labels := prometheus.Labels{
+		"fqdn":       result.FQDN,
+		"dns_server": result.DNSServer,
+	}
+	r.metrics.SOASerial.With(labels).Set(float64(result.SOA.Serial))
+	r.metrics.SOARefresh.With(labels).Set(float64(result.SOA.Refresh))
+	r.metrics.SOARetry.With(labels).Set(float64(result.SOA.Retry))
+	r.metrics.SOAExpire.With(labels).Set(float64(result.SOA.Expire))
+	r.metrics.SOAMinimumTTL.With(labels).Set(float64(result.SOA.Minttl))
+	r.recordSOASerial(result.FQDN, result.DNSServer, result.SOA.Serial)
+}
+
+// updatePTRMetrics exposes the hostnames of a PTR answer
+func (r *Resolver) updatePTRMetrics(result *Result, labels prometheus.Labels) {
+	r.metrics.ResolvedRecordCount.With(labels).Set(float64(len(result.PTR)))
+
+	series := make([]prometheus.Labels, 0, len(result.PTR))
+	for _, host := range result.PTR {
+		ptrLabels := prometheus.Labels{
+			"fqdn":       result.FQDN,
+			"dns_server": result.DNSServer,
+			"hostname":   host,
+		}
+		r.metrics.PTRRecord.With(ptrLabels).Set(1)
+		series = append(series, ptrLabels)
+	}
+	r.ptrSeries.replace(seriesKey(result), series)
+}
+
+// updateSRVMetrics exposes the targets, priorities and weights of an SRV answer
+func (r *Resolver) updateSRVMetrics(result *Result, labels prometheus.Labels) {
+	r.metrics.ResolvedRecordCount.With(labels).Set(float64(len(result.SRV)))
+
+	series := make([]prometheus.Labels, 0, len(result.SRV))
+	for _, srv := range result.SRV {
+		srvLabels := prometheus.Labels{
+			"fqdn":       result.FQDN,
+			"dns_server": result.DNSServer,
+			"target":     srv.Target,
+			"port":       strconv.Itoa(int(srv.Port)),
+		}
+		r.metrics.SRVRecord.With(srvLabels).Set(float64(srv.Priority))
+		r.metrics.SRVRecordWeight.With(srvLabels).Set(float64(srv.Weight))
+		series = append(series, srvLabels)
+	}
+	r.srvSeries.replace(seriesKey(result), series)
+	r.srvWeightSeries.replace(seriesKey(result), series)
+}
+
+// updateDSMetrics exposes the key tags, algorithms and digest types of a DS
+// answer
+func (r *Resolver) updateDSMetrics(result *Result, labels prometheus.Labels) {
+	r.metrics.ResolvedRecordCount.With(labels).Set(float64(len(result.DS)))
+
+	series := make([]prometheus.Labels, 0, len(result.DS))
+	for _, ds := range result.DS {
+		dsLabels := prometheus.Labels{
+			"fqdn":        result.FQDN,
+			"dns_server":  result.DNSServer,
+			"key_tag":     strconv.Itoa(int(ds.KeyTag)),
+			"algorithm":   strconv.Itoa(int(ds.Algorithm)),
+			"digest_type": strconv.Itoa(int(ds.DigestType)),
+		}
+		r.metrics.DSRecord.With(dsLabels).Set(1)
+		series = append(series, dsLabels)
+	}
+	r.dsSeries.replace(seriesKey(result), series)
+}
+
+// updateDNSKEYMetrics exposes the key tags, algorithms and flags of a
+// DNSKEY answer. Keys that leave the answer are removed so the end of a
+// rollover is visible.
+func (r *Resolver) updateDNSKEYMetrics(result *Result) {
+	r.metrics.DNSKEYCount.With(prometheus.Labels{
+		"fqdn":       result.FQDN,
+		"dns_server": result.DNSServer,
+	}).Set(float64(len(result.DNSKEY)))
+
+	series := make([]prometheus.Labels, 0, len(result.DNSKEY))
+	for _, key := range result.DNSKEY {
+		keyLabels := prometheus.Labels{
+			"fqdn":       result.FQDN,
+			"dns_server": result.DNSServer,
+			"key_tag":    strconv.Itoa(int(key.KeyTag())),
+			"algorithm":  strconv.Itoa(int(key.Algorithm)),
+			"flags":      strconv.Itoa(int(key.Flags)),
+		}
+		r.metrics.DNSKEYRecord.With(keyLabels).Set(1)
+		series = append(series, keyLabels)
+	}
+	r.dnskeySeries.replace(seriesKey(result), series)
+}
+
+// updateSVCBMetrics exposes the targets, priorities and parameter keys of an
+// HTTPS or SVCB answer
+func (r *Resolver) updateSVCBMetrics(result *Result, labels prometheus.Labels) {
+	r.metrics.ResolvedRecordCount.With(labels).Set(float64(len(result.SVCB)))
+
+	series := make([]prometheus.Labels, 0, len(result.SVCB))
+	var paramSeries []prometheus.Labels
+	for _, svcb := range result.SVCB {
+		recordLabels := prometheus.Labels{
 			"fqdn":        result.FQDN,
 			"record_type": result.RecordType,
 			"dns_server":  result.DNSServer,
-			"ip_address":  ip.IP.String(),
+			"target":      svcb.Target,
+			"priority":    strconv.Itoa(int(svcb.Priority)),
 		}
-		r.resolvedIpAddress.With(ipLabels).Set(1)
+		r.metrics.HTTPSRecord.With(recordLabels).Set(1)
+		series = append(series, recordLabels)
+
+		for _, kv := range svcb.Value {
+			paramLabels := prometheus.Labels{
+				"fqdn":        result.FQDN,
+				"record_type": result.RecordType,
+				"dns_server":  result.DNSServer,
+				"target":      svcb.Target,
+				"priority":    strconv.Itoa(int(svcb.Priority)),
+				"param":       kv.Key().String(),
+			}
+			r.metrics.HTTPSParam.With(paramLabels).Set(1)
+			paramSeries = append(paramSeries, paramLabels)
+		}
+	}
+	r.httpsSeries.replace(seriesKey(result), series)
+	r.httpsParamSeries.replace(seriesKey(result), paramSeries)
+}
+
+// updateCaseMetrics exposes whether the server preserved the randomized
+// case of the query name
+func (r *Resolver) updateCaseMetrics(result *Result) {
+	labels := prometheus.Labels{
+		"fqdn":       result.FQDN,
+		"dns_server": result.DNSServer,
+	}
+	r.metrics.CasePreserved.With(labels).Set(boolToFloat(result.CasePreserved))
+	if !result.CasePreserved {
+		r.metrics.CaseMismatchTotal.With(labels).Inc()
+	}
+}
+
+// updateNSIDMetrics exposes the NSID of the last response, replacing the
+// series of a previous NSID
+func (r *Resolver) updateNSIDMetrics(result *Result) {
+	nsidLabels := prometheus.Labels{
+		"fqdn":        result.FQDN,
+		"record_type": result.RecordType,
+		"dns_server":  result.DNSServer,
+		"nsid":        result.NSID,
+	}
+	r.metrics.ResponseNSIDInfo.With(nsidLabels).Set(1)
+	r.nsidSeries.replace(seriesKey(result), []prometheus.Labels{nsidLabels})
+}
+
+// seriesKey identifies the probe a result belongs to
+func seriesKey(result *Result) string {
+	return result.FQDN + "|" + result.RecordType + "|" + result.DNSServer
+}
+
+// updateTXTMetrics exposes the number of TXT strings and a hash of their
+// contents. The raw strings are never used as label values.
+func (r *Resolver) updateTXTMetrics(result *Result) {
+	labels := prometheus.Labels{
+		"fqdn":       result.FQDN,
+		"dns_server": result.DNSServer,
+	}
+	r.metrics.TXTRecordCount.With(labels).Set(float64(len(result.TXT)))
+	r.metrics.TXTRecordsHash.With(labels).Set(float64(hashStrings(result.TXT)))
+}
+
+// hashStrings returns a stable FNV-1a hash of the sorted strings, e.g. TXT
+// strings or IP addresses. A 32-bit hash is used so the value is exactly
+// representable as a float64.
+func hashStrings(strs []string) uint32 {
+	sorted := append([]string(nil), strs...)
+	sort.Strings(sorted)
+
+	h := fnv.New32a()
+	for _, str := range sorted {
+		h.Write([]byte(str))
+		h.Write([]byte{0})
 	}
+	return h.Sum32()
 }
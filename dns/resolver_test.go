@@ -0,0 +1,56 @@
+package dns
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	mdns "github.com/miekg/dns"
+)
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		name  string
+		err   error
+		rcode int
+		want  string
+	}{
+		{"proxy", fmt.Errorf("%w: connection refused", ErrProxy), RcodeNoResponse, ErrorClassProxy},
+		{"proxy timeout", fmt.Errorf("%w: %w", ErrProxy, context.DeadlineExceeded), RcodeNoResponse, ErrorClassProxy},
+		{"untrusted certificate", &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}, RcodeNoResponse, ErrorClassTLS},
+		{"certificate for another name", x509.HostnameError{Host: "dns.example"}, RcodeNoResponse, ErrorClassTLS},
+		{"expired certificate", x509.CertificateInvalidError{Reason: x509.Expired}, RcodeNoResponse, ErrorClassTLS},
+		{"tls alert", tls.AlertError(42), RcodeNoResponse, ErrorClassTLS},
+		{"context deadline", context.DeadlineExceeded, RcodeNoResponse, ErrorClassTimeout},
+		{"net timeout", &net.OpError{Op: "read", Net: "udp", Err: os.ErrDeadlineExceeded}, RcodeNoResponse, ErrorClassTimeout},
+		{"resolver timeout", &net.DNSError{Err: "i/o timeout", Name: "example.com", IsTimeout: true}, RcodeNoResponse, ErrorClassTimeout},
+		{"nxdomain rcode", errors.New("NXDOMAIN"), mdns.RcodeNameError, ErrorClassNXDomain},
+		{"resolver not found", &net.DNSError{Err: "no such host", Name: "example.com", IsNotFound: true}, mdns.RcodeNameError, ErrorClassNXDomain},
+		{"servfail", errors.New("SERVFAIL"), mdns.RcodeServerFailure, ErrorClassServFail},
+		{"refused", errors.New("REFUSED"), mdns.RcodeRefused, ErrorClassRefused},
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}, RcodeNoResponse, ErrorClassNetwork},
+		{"resolver error", &net.DNSError{Err: "server misbehaving", Name: "example.com"}, RcodeNoResponse, ErrorClassNetwork},
+		{"quic handshake", fmt.Errorf("%w: no route", ErrQUICHandshake), RcodeNoResponse, ErrorClassNetwork},
+		{"connection closed", io.EOF, RcodeNoResponse, ErrorClassNetwork},
+		{"truncated read", fmt.Errorf("read response: %w", io.ErrUnexpectedEOF), RcodeNoResponse, ErrorClassNetwork},
+		{"cname chain too long", ErrCNAMEDepthExceeded, mdns.RcodeSuccess, ErrorClassCNAME},
+		{"cname loop", fmt.Errorf("resolve www.example.com: %w", ErrCNAMELoop), mdns.RcodeSuccess, ErrorClassCNAME},
+		{"empty answer", errors.New("no A records in the answer"), mdns.RcodeSuccess, ErrorClassOther},
+		{"unexpected resolution", ErrUnexpectedResolution, mdns.RcodeSuccess, ErrorClassOther},
+		{"format error", errors.New("FORMERR"), mdns.RcodeFormatError, ErrorClassOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &Result{Error: tt.err, Rcode: tt.rcode}
+			if got := errorClass(result); got != tt.want {
+				t.Errorf("errorClass(%v, rcode %d) = %q, want %q", tt.err, tt.rcode, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package dns
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// readSecret reads the secret what from path, trimming surrounding white
+// space. Secrets are read when they are used, so rotated files are picked up
+// without a restart. Errors name the file but never its contents.
+func readSecret(what, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", what, err)
+	}
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return "", fmt.Errorf("%s: %s is empty", what, path)
+	}
+	return secret, nil
+}
@@ -0,0 +1,121 @@
+package dns
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// seriesTracker remembers which label sets were exported on a gauge vector
+// for each probe, so series that drop out of an answer can be deleted
+// instead of keeping their last value forever.
+type seriesTracker struct {
+	mu     sync.Mutex
+	vec    *prometheus.GaugeVec
+	series map[string]map[string]prometheus.Labels
+}
+
+func newSeriesTracker(vec *prometheus.GaugeVec) *seriesTracker {
+	return &seriesTracker{
+		vec:    vec,
+		series: make(map[string]map[string]prometheus.Labels),
+	}
+}
+
+// replace records current as the label sets exported for key and deletes
+// any series exported for key previously that are not part of current.
+func (t *seriesTracker) replace(key string, current []prometheus.Labels) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	next := make(map[string]prometheus.Labels, len(current))
+	for _, labels := range current {
+		next[labelsKey(labels)] = labels
+	}
+
+	for id, labels := range t.series[key] {
+		if _, ok := next[id]; !ok {
+			t.vec.Delete(labels)
+		}
+	}
+	t.series[key] = next
+}
+
+// forget stops tracking the label sets matching all of match, after they
+// were deleted from the vector by Resolver.Forget
+func (t *seriesTracker) forget(match prometheus.Labels) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key, series := range t.series {
+		for id, labels := range series {
+			if matchesLabels(labels, match) {
+				delete(series, id)
+			}
+		}
+		if len(series) == 0 {
+			delete(t.series, key)
+		}
+	}
+}
+
+// matchesLabels reports whether labels has every label of match with the
+// same value
+func matchesLabels(labels, match prometheus.Labels) bool {
+	for name, value := range match {
+		if v, ok := labels[name]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Forget deletes every series matching all of match from all metric vectors,
+// e.g. {"fqdn": "example.com"} for a target removed from the configuration
+func (r *Resolver) Forget(match prometheus.Labels) {
+	// Every collector in Metrics is a vector, they are walked by reflection
+	// so newly added metrics are covered without being listed here
+	metrics := reflect.ValueOf(r.metrics)
+	for i := 0; i < metrics.NumField(); i++ {
+		vec, ok := metrics.Field(i).Interface().(interface {
+			DeletePartialMatch(prometheus.Labels) int
+		})
+		if ok && !metrics.Field(i).IsNil() {
+			vec.DeletePartialMatch(match)
+		}
+	}
+
+	for _, tracker := range []*seriesTracker{
+		r.ipSeries, r.mxSeries, r.nsSeries, r.ptrSeries, r.srvSeries,
+		r.srvWeightSeries, r.nsidSeries, r.chaosSeries, r.httpsSeries,
+		r.httpsParamSeries, r.traceSeries, r.wildcardSeries, r.dsSeries,
+		r.dnskeySeries, r.lastErrorSeries,
+	} {
+		tracker.forget(match)
+	}
+	r.scopes.forget(match)
+	r.forgetAvailability(match)
+	r.forgetEWMA(match)
+	r.forgetFailures(match)
+}
+
+// labelsKey returns a canonical string form of a label set
+func labelsKey(labels prometheus.Labels) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(labels[name])
+		b.WriteByte(0)
+	}
+	return b.String()
+}
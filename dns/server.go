@@ -0,0 +1,170 @@
+package dns
+
+import (
+	"crypto/tls"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Transport protocols a server can be queried over
+const (
+	// ProtocolDo53 is plain DNS over UDP, retried over TCP when truncated
+	ProtocolDo53 = "do53"
+	// ProtocolUDP is plain DNS over UDP only, without TCP fallback
+	ProtocolUDP = "udp"
+	// ProtocolTCP is plain DNS over TCP only
+	ProtocolTCP = "tcp"
+	// ProtocolDoT is DNS over TLS (RFC 7858)
+	ProtocolDoT = "dot"
+	// ProtocolDoQ is DNS over QUIC (RFC 9250)
+	ProtocolDoQ = "doq"
+	// ProtocolDoH is DNS over HTTPS (RFC 8484), the address is the URL
+	ProtocolDoH = "doh"
+)
+
+// Address families a server can be reached over
+const (
+	// FamilyAny lets the system pick the address family
+	FamilyAny = "any"
+	// FamilyIPv4 only connects over IPv4
+	FamilyIPv4 = "ipv4"
+	// FamilyIPv6 only connects over IPv6
+	FamilyIPv6 = "ipv6"
+)
+
+// Server describes a DNS server queried by the resolver
+type Server struct {
+	Name    string
+	Address string
+
+	// EDNS0 UDP payload size advertised in queries (0 = no EDNS0)
+	EDNSBufferSize uint16
+
+	// Request the name server identifier (NSID) in queries
+	NSID bool
+
+	// Send DNS cookies (RFC 7873) in queries
+	Cookies bool
+
+	// Transport protocol ("" = ProtocolDo53)
+	Protocol string
+
+	// TLS settings for DoT, DoQ and DoH (nil = system roots, server name from
+	// the address)
+	TLSConfig *tls.Config
+
+	// Open a new QUIC connection for every DoQ query instead of reusing one
+	// connection across queries and cycles
+	DoQFreshConnection bool
+
+	// Local IP address queries are sent from ("" = chosen by the system)
+	SourceAddress string
+
+	// Address family used to reach the server ("" = FamilyAny)
+	TransportFamily string
+
+	// SOCKS5 proxy TCP, DoT and DoH connections are made through, or HTTP(S)
+	// proxy of DoH connections (nil = direct)
+	ProxyURL *url.URL
+
+	// Extra HTTP headers sent with DoH queries
+	Headers map[string]string
+	// Extra HTTP headers sent with DoH queries whose values are read from
+	// files, like BearerTokenFile
+	HeaderFiles map[string]string
+
+	// File holding a bearer token sent with DoH queries. It is read for
+	// every query so a rotated token is picked up without a restart.
+	BearerTokenFile string
+
+	// Use the name instead of the address as the dns_server label
+	LabelByName bool
+
+	// Name whose SOA record is queried by the health check ("" =
+	// DefaultHealthCheckQuery)
+	HealthCheckQuery string
+
+	// Timeout of the queries to the server, lookups, health checks,
+	// wildcard checks and CHAOS queries
+	Timeout time.Duration
+
+	// Job of the server, only the targets of the same job are queried
+	// against it ("" = top-level)
+	Job string
+
+	// Tags targets select the server by
+	Tags []string
+
+	// DoQ connections shared across cycles, set by the resolver
+	doqConns *doqPool
+
+	// DoH clients kept across cycles, set by the resolver
+	dohClients *dohPool
+}
+
+// Label returns the dns_server label value of the server, its name if the
+// server is labeled by name and its address label otherwise
+func (s Server) Label() string {
+	if s.LabelByName && s.Name != "" {
+		return s.Name
+	}
+	return s.AddressLabel()
+}
+
+// AddressLabel identifies the server by its address. Servers queried over
+// anything but Do53 are prefixed with the protocol, so the same resolver can
+// be monitored over several protocols side by side. DoH servers are labeled
+// with their URL.
+func (s Server) AddressLabel() string {
+	if s.Protocol == "" || s.Protocol == ProtocolDo53 || s.Protocol == ProtocolDoH {
+		return s.Address
+	}
+	return s.Protocol + "://" + s.Address
+}
+
+// network returns the network to dial for base ("udp" or "tcp"),
+// constrained to the transport family of the server
+func (s Server) network(base string) string {
+	switch s.TransportFamily {
+	case FamilyIPv4:
+		return base + "4"
+	case FamilyIPv6:
+		return base + "6"
+	}
+	return base
+}
+
+// dialer returns a dialer for network bound to the source address of the
+// server, if any
+func (s Server) dialer(network string) *net.Dialer {
+	d := &net.Dialer{}
+	if s.SourceAddress == "" {
+		return d
+	}
+
+	ip := net.ParseIP(s.SourceAddress)
+	if network == "" || strings.HasPrefix(network, "udp") {
+		d.LocalAddr = &net.UDPAddr{IP: ip}
+	} else {
+		d.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	return d
+}
+
+// dialAddress returns the host:port to connect to, using the default port
+// of the protocol when the address has none. IPv6 literals are bracketed
+// by net.JoinHostPort.
+func (s Server) dialAddress() string {
+	if _, _, err := net.SplitHostPort(s.Address); err == nil {
+		return s.Address
+	}
+
+	port := "53"
+	if s.Protocol == ProtocolDoT || s.Protocol == ProtocolDoQ {
+		port = "853"
+	}
+	host := strings.TrimSuffix(strings.TrimPrefix(s.Address, "["), "]")
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,52 @@
+package dns
+
+import "testing"
+
+func TestDialAddress(t *testing.T) {
+	tests := []struct {
+		name     string
+		address  string
+		protocol string
+		want     string
+	}{
+		{"ipv4", "8.8.8.8", "", "8.8.8.8:53"},
+		{"ipv4 with port", "8.8.8.8:5353", "", "8.8.8.8:5353"},
+		{"ipv6", "2606:4700:4700::1111", "", "[2606:4700:4700::1111]:53"},
+		{"bracketed ipv6", "[2606:4700:4700::1111]", "", "[2606:4700:4700::1111]:53"},
+		{"ipv6 with port", "[2606:4700:4700::1111]:5353", "", "[2606:4700:4700::1111]:5353"},
+		{"ipv6 loopback", "::1", ProtocolTCP, "[::1]:53"},
+		{"hostname", "dns.google", "", "dns.google:53"},
+		{"hostname with port", "dns.google:5353", "", "dns.google:5353"},
+		{"dot ipv4", "1.1.1.1", ProtocolDoT, "1.1.1.1:853"},
+		{"dot ipv6", "2606:4700:4700::1111", ProtocolDoT, "[2606:4700:4700::1111]:853"},
+		{"doq hostname", "dns.adguard-dns.com", ProtocolDoQ, "dns.adguard-dns.com:853"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := Server{Address: tt.address, Protocol: tt.protocol}
+			if got := server.dialAddress(); got != tt.want {
+				t.Errorf("dialAddress() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddressLabel(t *testing.T) {
+	tests := []struct {
+		address  string
+		protocol string
+		want     string
+	}{
+		{"8.8.8.8", "", "8.8.8.8"},
+		{"2001:4860:4860::8888", ProtocolDo53, "2001:4860:4860::8888"},
+		{"dns.google", ProtocolTCP, "tcp://dns.google"},
+		{"[2606:4700:4700::1111]:853", ProtocolDoT, "dot://[2606:4700:4700::1111]:853"},
+		{"https://dns.google/dns-query", ProtocolDoH, "https://dns.google/dns-query"},
+	}
+	for _, tt := range tests {
+		server := Server{Address: tt.address, Protocol: tt.protocol}
+		if got := server.AddressLabel(); got != tt.want {
+			t.Errorf("AddressLabel() of %s over %q = %q, want %q", tt.address, tt.protocol, got, tt.want)
+		}
+	}
+}
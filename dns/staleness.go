@@ -0,0 +1,75 @@
+package dns
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// probeScopes remembers when the series of each probed scope were last
+// updated. A scope is a label set the series of a probe share, e.g.
+// {fqdn, record_type, dns_server} for a lookup, so the series of scopes no
+// longer probed can be found without tracking every series.
+type probeScopes struct {
+	mu     sync.Mutex
+	scopes map[string]probeScope
+}
+
+type probeScope struct {
+	labels prometheus.Labels
+	seen   time.Time
+}
+
+func newProbeScopes() *probeScopes {
+	return &probeScopes{scopes: make(map[string]probeScope)}
+}
+
+// touch marks each scope as updated now
+func (p *probeScopes) touch(scopes ...prometheus.Labels) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	for _, labels := range scopes {
+		p.scopes[labelsKey(labels)] = probeScope{labels: labels, seen: now}
+	}
+}
+
+// forget stops tracking the scopes matching all of match
+func (p *probeScopes) forget(match prometheus.Labels) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, scope := range p.scopes {
+		if matchesLabels(scope.labels, match) {
+			delete(p.scopes, key)
+		}
+	}
+}
+
+// stale returns the scopes not updated within ttl
+func (p *probeScopes) stale(ttl time.Duration) []prometheus.Labels {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var stale []prometheus.Labels
+	for _, scope := range p.scopes {
+		if time.Since(scope.seen) > ttl {
+			stale = append(stale, scope.labels)
+		}
+	}
+	return stale
+}
+
+// ExpireStale deletes the series of every probe not performed within ttl,
+// e.g. of a server that is no longer queried, and returns how many probe
+// scopes expired. Lookups, health checks, wildcard checks and CHAOS queries
+// are covered.
+func (r *Resolver) ExpireStale(ttl time.Duration) int {
+	stale := r.scopes.stale(ttl)
+	for _, labels := range stale {
+		r.Forget(labels)
+	}
+	return len(stale)
+}
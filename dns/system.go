@@ -0,0 +1,92 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	mdns "github.com/miekg/dns"
+)
+
+// SystemAddress is the Server.Address that selects the host's own resolver
+// configuration (resolv.conf, nsswitch) instead of a specific server
+const SystemAddress = "system"
+
+// System reports whether s resolves through the host's resolver
+func (s Server) System() bool {
+	return s.Address == SystemAddress
+}
+
+// lookupSystem resolves result.name with the default net.Resolver. Only the
+// record types net.Resolver can look up are supported, and since no raw
+// response is available TTLs, rcodes other than NXDOMAIN and EDNS options
+// are not reported.
+func lookupSystem(ctx context.Context, result *Result) error {
+	resolver := net.DefaultResolver
+
+	var err error
+	switch result.RecordType {
+	case "A", "AAAA":
+		network := "ip4"
+		if result.RecordType == "AAAA" {
+			network = "ip6"
+		}
+		var ips []net.IP
+		ips, err = resolver.LookupIP(ctx, network, result.name)
+		for _, ip := range ips {
+			result.IPs = append(result.IPs, net.IPAddr{IP: ip})
+		}
+	case "MX":
+		result.MX, err = resolver.LookupMX(ctx, result.name)
+	case "TXT":
+		result.TXT, err = resolver.LookupTXT(ctx, result.name)
+	case "NS":
+		result.NS, err = resolver.LookupNS(ctx, result.name)
+	case "PTR":
+		// LookupAddr takes an IP address, reverse names are converted back
+		address := result.name
+		if net.ParseIP(address) == nil {
+			address = reverseNameAddress(address)
+		}
+		result.PTR, err = resolver.LookupAddr(ctx, address)
+	case "SRV":
+		var srvs []*net.SRV
+		_, srvs, err = resolver.LookupSRV(ctx, "", "", result.name)
+		for _, srv := range srvs {
+			result.SRV = append(result.SRV, &mdns.SRV{
+				Hdr:      mdns.RR_Header{Name: mdns.Fqdn(result.name), Rrtype: mdns.TypeSRV, Class: mdns.ClassINET},
+				Priority: srv.Priority,
+				Weight:   srv.Weight,
+				Port:     srv.Port,
+				Target:   srv.Target,
+			})
+		}
+	case "SOA", "HTTPS", "SVCB", "DNSKEY", "DS":
+		err = fmt.Errorf("record type %s is not supported by the system resolver", result.RecordType)
+	default:
+		result.IPs, err = resolver.LookupIPAddr(ctx, result.name)
+	}
+	return err
+}
+
+// reverseNameAddress converts an in-addr.arpa or ip6.arpa name back to the
+// IP address it stands for, or returns name unchanged if it is neither
+func reverseNameAddress(name string) string {
+	labels := mdns.SplitDomainName(strings.ToLower(name))
+	n := len(labels)
+	switch {
+	case n == 6 && labels[4] == "in-addr" && labels[5] == "arpa":
+		return labels[3] + "." + labels[2] + "." + labels[1] + "." + labels[0]
+	case n == 34 && labels[32] == "ip6" && labels[33] == "arpa":
+		var ip []byte
+		for i := 31; i >= 0; i-- {
+			ip = append(ip, labels[i]...)
+			if i%4 == 0 && i > 0 {
+				ip = append(ip, ':')
+			}
+		}
+		return string(ip)
+	}
+	return name
+}
@@ -0,0 +1,316 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// rootServers are the IPv4 addresses of the root name servers, where a trace
+// starts unless another starting point is configured
+var rootServers = []string{
+	"198.41.0.4",     // a.root-servers.net
+	"170.247.170.2",  // b.root-servers.net
+	"192.33.4.12",    // c.root-servers.net
+	"199.7.91.13",    // d.root-servers.net
+	"192.203.230.10", // e.root-servers.net
+	"192.5.5.241",    // f.root-servers.net
+	"192.112.36.4",   // g.root-servers.net
+	"198.97.190.53",  // h.root-servers.net
+	"192.36.148.17",  // i.root-servers.net
+	"192.58.128.30",  // j.root-servers.net
+	"193.0.14.129",   // k.root-servers.net
+	"199.7.83.42",    // l.root-servers.net
+	"202.12.27.33",   // m.root-servers.net
+}
+
+// maxTraceHops bounds the number of referrals a trace follows
+const maxTraceHops = 16
+
+var (
+	// errNoReferral is returned when a non-authoritative response contains
+	// neither an answer nor a referral to a child zone
+	errNoReferral = errors.New("no answer or referral")
+
+	// errNoServerAddress is returned when none of the name servers of a zone
+	// could be resolved to an address
+	errNoServerAddress = errors.New("no name server address")
+
+	// errTooManyHops is returned when a trace exceeds maxTraceHops
+	errTooManyHops = errors.New("too many referrals")
+)
+
+// Trace describes an iterative resolution of a name, following referrals
+// down from the root like dig +trace
+type Trace struct {
+	FQDN string
+	// Name traced when it differs from FQDN, see Query.Name
+	Name string
+
+	// Zone the trace starts at ("" = the root)
+	StartZone string
+	// Addresses of the servers of StartZone, looked up with the system
+	// resolver when empty (the root servers are built in)
+	StartServers []string
+
+	// Local address queries are sent from ("" = chosen by the system)
+	SourceAddress string
+}
+
+// name returns the name traced
+func (t Trace) name() string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return t.FQDN
+}
+
+// traceHop is one query sent during a trace
+type traceHop struct {
+	zone     string
+	server   string
+	duration time.Duration
+	err      error
+}
+
+// Trace resolves the name iteratively and exposes the duration of each hop,
+// the total duration and the number of hops. Servers that fail to answer
+// are counted with an error class, and the next server of the zone is tried.
+func (r *Resolver) Trace(trace Trace, timeout time.Duration) error {
+	start := time.Now()
+
+	hops, err := traceName(trace, timeout)
+
+	labels := prometheus.Labels{"fqdn": trace.FQDN}
+	r.metrics.TraceTotalDuration.With(labels).Set(time.Since(start).Seconds())
+
+	var series []prometheus.Labels
+	for _, hop := range hops {
+		hopLabels := prometheus.Labels{
+			"fqdn":   trace.FQDN,
+			"zone":   hop.zone,
+			"server": hop.server,
+		}
+		if hop.err != nil {
+			hopLabels["error"] = traceErrorClass(hop.err)
+			r.metrics.TraceFailuresTotal.With(hopLabels).Inc()
+			continue
+		}
+		r.metrics.TraceHopDuration.With(hopLabels).Set(hop.duration.Seconds())
+		series = append(series, hopLabels)
+	}
+	r.traceSeries.replace(trace.FQDN, series)
+	r.metrics.TraceHops.With(labels).Set(float64(len(series)))
+
+	if err != nil {
+		r.metrics.TraceSuccess.With(labels).Set(0)
+		return err
+	}
+	r.metrics.TraceSuccess.With(labels).Set(1)
+	return nil
+}
+
+// traceName follows referrals for trace.FQDN until a server answers
+// authoritatively and returns every query sent on the way
+func traceName(trace Trace, timeout time.Duration) ([]traceHop, error) {
+	zone, servers := traceStart(trace.StartZone, trace.StartServers, timeout)
+	hops, _, _, err := followReferrals(mdns.Fqdn(trace.name()), mdns.TypeA, zone, servers, "", trace.SourceAddress, timeout)
+	if err != nil {
+		return hops, fmt.Errorf("trace %s: %w", trace.FQDN, err)
+	}
+	return hops, nil
+}
+
+// traceStart returns the zone and server addresses iterative resolution
+// starts at: the root unless a start zone or servers are given
+func traceStart(startZone string, startServers []string, timeout time.Duration) (string, []string) {
+	zone := "."
+	servers := rootServers
+	if startZone != "" {
+		zone = mdns.Fqdn(startZone)
+	}
+	if len(startServers) > 0 {
+		servers = startServers
+	} else if startZone != "" {
+		servers = lookupZoneServers(zone, timeout)
+	}
+	return zone, servers
+}
+
+// followReferrals sends non-recursive queries for fqdn to the servers of
+// zone and follows referrals until a server answers authoritatively, or
+// until a referral to stopAt when it is set. It returns every query sent,
+// the last response and, if that response is a referral, the child zone.
+func followReferrals(fqdn string, qtype uint16, zone string, servers []string, stopAt, source string, timeout time.Duration) ([]traceHop, *mdns.Msg, string, error) {
+	var hops []traceHop
+	for range maxTraceHops {
+		if len(servers) == 0 {
+			hops = append(hops, traceHop{zone: zone, err: errNoServerAddress})
+			return hops, nil, "", fmt.Errorf("zone %s: %w", zone, errNoServerAddress)
+		}
+
+		var resp *mdns.Msg
+		var child string
+		for _, server := range servers {
+			var err error
+			hop := traceHop{zone: zone, server: server}
+			resp, child, hop.duration, err = queryTraceServer(server, zone, fqdn, qtype, source, timeout)
+			hop.err = err
+			hops = append(hops, hop)
+			if err == nil {
+				break
+			}
+			resp = nil
+		}
+		if resp == nil {
+			last := hops[len(hops)-1]
+			return hops, nil, "", fmt.Errorf("zone %s: %w", zone, last.err)
+		}
+
+		if child == "" || child == stopAt {
+			return hops, resp, child, nil
+		}
+		zone = child
+		servers = referralServers(resp, child, timeout)
+	}
+
+	return hops, nil, "", errTooManyHops
+}
+
+// queryTraceServer sends a non-recursive query for fqdn to a server of zone
+// from the source address. It returns the response and, for referrals, the
+// child zone delegated to.
+func queryTraceServer(server, zone, fqdn string, qtype uint16, source string, timeout time.Duration) (*mdns.Msg, string, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	iterative := Server{Address: server, EDNSBufferSize: defaultEDNSBufferSize, SourceAddress: source}
+	reply, err := exchange(ctx, iterative, Query{noRecursion: true}, fqdn, qtype)
+	duration := time.Since(start)
+	if err != nil {
+		return nil, "", duration, err
+	}
+
+	resp := reply.msg
+	if len(resp.Answer) > 0 || resp.Authoritative {
+		return resp, "", duration, nil
+	}
+
+	// A referral delegates a zone below the current one that contains fqdn
+	for _, rr := range resp.Ns {
+		ns, ok := rr.(*mdns.NS)
+		if !ok {
+			continue
+		}
+		child := mdns.CanonicalName(ns.Hdr.Name)
+		if child != mdns.CanonicalName(zone) && mdns.IsSubDomain(zone, child) && mdns.IsSubDomain(child, fqdn) {
+			return resp, child, duration, nil
+		}
+	}
+	return nil, "", duration, errNoReferral
+}
+
+// referralServers returns the addresses of the name servers of child listed
+// in a referral, taken from the glue records when present and looked up with
+// the system resolver otherwise. IPv4 addresses are listed first.
+func referralServers(resp *mdns.Msg, child string, timeout time.Duration) []string {
+	var hosts []string
+	for _, rr := range resp.Ns {
+		if ns, ok := rr.(*mdns.NS); ok && mdns.CanonicalName(ns.Hdr.Name) == child {
+			hosts = append(hosts, mdns.CanonicalName(ns.Ns))
+		}
+	}
+
+	var v4, v6 []string
+	for _, rr := range resp.Extra {
+		switch rr := rr.(type) {
+		case *mdns.A:
+			if containsName(hosts, rr.Hdr.Name) {
+				v4 = append(v4, rr.A.String())
+			}
+		case *mdns.AAAA:
+			if containsName(hosts, rr.Hdr.Name) {
+				v6 = append(v6, rr.AAAA.String())
+			}
+		}
+	}
+	if len(v4)+len(v6) > 0 {
+		return append(v4, v6...)
+	}
+	return lookupHosts(hosts, timeout)
+}
+
+// lookupZoneServers returns the addresses of the name servers of zone
+// according to the system resolver
+func lookupZoneServers(zone string, timeout time.Duration) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	nss, err := net.DefaultResolver.LookupNS(ctx, zone)
+	if err != nil {
+		return nil
+	}
+	hosts := make([]string, 0, len(nss))
+	for _, ns := range nss {
+		hosts = append(hosts, ns.Host)
+	}
+	return lookupHosts(hosts, timeout)
+}
+
+// lookupHosts resolves host names with the system resolver, IPv4 addresses
+// first
+func lookupHosts(hosts []string, timeout time.Duration) []string {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var v4, v6 []string
+	for _, host := range hosts {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if addr.IP.To4() != nil {
+				v4 = append(v4, addr.IP.String())
+			} else {
+				v6 = append(v6, addr.IP.String())
+			}
+		}
+	}
+	return append(v4, v6...)
+}
+
+// containsName reports whether names contains name, ignoring case
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// traceErrorClass returns the error label value for a failed trace hop
+func traceErrorClass(err error) string {
+	var rcodeErr *rcodeError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, errNoReferral):
+		return "no_referral"
+	case errors.Is(err, errNoServerAddress):
+		return "no_server_address"
+	case errors.As(err, &rcodeErr):
+		return strings.ToLower(mdns.RcodeToString[rcodeErr.rcode])
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	default:
+		return "network"
+	}
+}
@@ -0,0 +1,78 @@
+package dns
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// CheckWildcard queries a random label under zone, which should not exist,
+// and reports whether it resolved anyway and to which addresses. The label
+// changes on every call so a cached answer cannot hide a wildcard. The label
+// is added to queryName, the name of zone in queries (see Query.Name).
+func (r *Resolver) CheckWildcard(zone, queryName string, server Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	server.doqConns = r.doqConns
+	server.dohClients = r.dohClients
+
+	name := randomLabel() + "." + mdns.Fqdn(queryName)
+
+	labels := prometheus.Labels{
+		"zone":       zone,
+		"dns_server": server.Label(),
+	}
+	r.scopes.touch(labels)
+
+	var ips []string
+	for _, qtype := range []uint16{mdns.TypeA, mdns.TypeAAAA} {
+		reply, err := exchange(ctx, server, Query{}, name, qtype)
+		var rcodeErr *rcodeError
+		if errors.As(err, &rcodeErr) && rcodeErr.rcode == mdns.RcodeNameError {
+			// NXDOMAIN is the expected answer
+			break
+		}
+		if err != nil {
+			r.metrics.WildcardCheckSuccess.With(labels).Set(0)
+			return err
+		}
+		for _, rr := range reply.msg.Answer {
+			switch rr := rr.(type) {
+			case *mdns.A:
+				ips = append(ips, rr.A.String())
+			case *mdns.AAAA:
+				ips = append(ips, rr.AAAA.String())
+			}
+		}
+	}
+
+	r.metrics.WildcardCheckSuccess.With(labels).Set(1)
+	r.metrics.WildcardPresent.With(labels).Set(boolToFloat(len(ips) > 0))
+
+	series := make([]prometheus.Labels, 0, len(ips))
+	for _, ip := range ips {
+		ipLabels := prometheus.Labels{
+			"zone":       zone,
+			"dns_server": server.Label(),
+			"ip_address": ip,
+		}
+		r.metrics.WildcardIP.With(ipLabels).Set(1)
+		series = append(series, ipLabels)
+	}
+	r.wildcardSeries.replace(zone+"|"+server.Label(), series)
+
+	return nil
+}
+
+// randomLabel returns a DNS label that is very unlikely to exist
+func randomLabel() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "wildcard-probe-" + hex.EncodeToString(b)
+}
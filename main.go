@@ -1,13 +1,32 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"flag"
+	"fmt"
 	"log"
+	mrand "math/rand/v2"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
+	"github.com/fsnotify/fsnotify"
+	kitlog "github.com/go-kit/log"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/exporter-toolkit/web"
 	"github.com/ys3669/dns-track-expoter/config"
 	"github.com/ys3669/dns-track-expoter/dns"
 )
@@ -19,16 +38,27 @@ var (
 			Name: "dns_response_time_seconds",
 			Help: "DNS response time in seconds",
 		},
-		[]string{"fqdn", "record_type", "dns_server"},
+		[]string{"fqdn", "record_type", "dns_server", "client_subnet"},
 	)
 
+	// DNS response time distribution, created once the buckets are known
+	// from the configuration
+	dnsResponseDuration *prometheus.HistogramVec
+
+	// DNS response time distribution per server, created with the buckets
+	// of dnsResponseDuration
+	dnsServerResponseDuration *prometheus.HistogramVec
+
+	// DNS response time quantiles, only created when configured
+	dnsResponseDurationQuantiles *prometheus.SummaryVec
+
 	// DNS resolution success/failure
 	dnsResolutionSuccess = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "dns_resolution_success",
 			Help: "DNS resolution success (1 = success, 0 = failure)",
 		},
-		[]string{"fqdn", "record_type", "dns_server"},
+		[]string{"fqdn", "record_type", "dns_server", "client_subnet"},
 	)
 
 	// Number of resolved IP addresses
@@ -37,7 +67,7 @@ var (
 			Name: "dns_resolved_ip_count",
 			Help: "Number of IP addresses resolved for FQDN",
 		},
-		[]string{"fqdn", "record_type", "dns_server"},
+		[]string{"fqdn", "record_type", "dns_server", "client_subnet"},
 	)
 
 	// Total DNS query count
@@ -46,7 +76,7 @@ var (
 			Name: "dns_query_total",
 			Help: "Total number of DNS queries performed",
 		},
-		[]string{"fqdn", "record_type", "dns_server", "status"},
+		[]string{"fqdn", "record_type", "dns_server", "status", "rcode", "error_class"},
 	)
 
 	// Resolved IP addresses (1 = IP exists for FQDN)
@@ -55,73 +85,1937 @@ var (
 			Name: "dns_resolved_ip_address",
 			Help: "Resolved IP addresses for FQDN (1 = IP exists)",
 		},
-		[]string{"fqdn", "record_type", "dns_server", "ip_address"},
+		[]string{"fqdn", "record_type", "dns_server", "client_subnet", "ip_address"},
 	)
-)
 
-var (
-	// Custom registry without Go runtime metrics
-	customRegistry = prometheus.NewRegistry()
-)
+	// Number of records returned for non-address record types
+	dnsResolvedRecordCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_resolved_record_count",
+			Help: "Number of records resolved for FQDN",
+		},
+		[]string{"fqdn", "record_type", "dns_server"},
+	)
 
-func init() {
-	// Register metrics with custom registry (not default one)
-	customRegistry.MustRegister(dnsResponseTime)
-	customRegistry.MustRegister(dnsResolutionSuccess)
-	customRegistry.MustRegister(dnsResolvedIpCount)
-	customRegistry.MustRegister(dnsQueryTotal)
-	customRegistry.MustRegister(dnsResolvedIpAddress)
-}
+	// MX records (value = preference)
+	dnsMXRecord = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_mx_record",
+			Help: "MX records for FQDN (value = preference)",
+		},
+		[]string{"fqdn", "dns_server", "exchange"},
+	)
 
-func main() {
-	// Parse command line flags
-	configFile := flag.String("config", "config.yaml", "Path to configuration file")
-	flag.Parse()
+	// Number of TXT strings returned
+	dnsTXTRecordCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_txt_record_count",
+			Help: "Number of TXT records resolved for FQDN",
+		},
+		[]string{"fqdn", "dns_server"},
+	)
 
-	// Load configuration
-	cfg, err := config.LoadConfig(*configFile)
-	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
-	}
+	// Hash of the sorted TXT strings
+	dnsTXTRecordsHash = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_txt_records_hash",
+			Help: "FNV-1a hash of the sorted TXT records for FQDN",
+		},
+		[]string{"fqdn", "dns_server"},
+	)
 
-	log.Printf("Starting DNS trace exporter on port %d", cfg.Server.Port)
-	log.Printf("Monitoring interval: %v", cfg.Monitoring.Interval)
-	log.Printf("DNS timeout: %v", cfg.Monitoring.Timeout)
+	// NS records (1 = nameserver exists for FQDN)
+	dnsNSRecord = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_ns_record",
+			Help: "NS records for FQDN (1 = nameserver exists)",
+		},
+		[]string{"fqdn", "dns_server", "nameserver"},
+	)
 
-	// Create DNS resolver
-	resolver := dns.NewResolver(
-		dnsResponseTime,
-		dnsResolutionSuccess,
-		dnsResolvedIpCount,
-		dnsQueryTotal,
-		dnsResolvedIpAddress,
+	// SOA serial number
+	dnsSOASerial = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_soa_serial",
+			Help: "SOA serial number for FQDN",
+		},
+		[]string{"fqdn", "dns_server"},
 	)
 
-	// Start DNS monitoring
-	go func() {
-		ticker := time.NewTicker(cfg.Monitoring.Interval)
-		defer ticker.Stop()
+	// SOA refresh timer in seconds
+	dnsSOARefresh = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_soa_refresh_seconds",
+			Help: "SOA refresh interval in seconds",
+		},
+		[]string{"fqdn", "dns_server"},
+	)
 
-		for {
-			for _, target := range cfg.Targets {
-				for _, dnsServer := range cfg.DNSServers {
-					for _, recordType := range target.RecordTypes {
-						log.Printf("Resolving %s (%s) via %s (%s)", target.FQDN, recordType, dnsServer.Name, dnsServer.Address)
-						resolver.Lookup(target.FQDN, dnsServer.Address, recordType, cfg.Monitoring.Timeout)
-					}
-				}
-			}
-			<-ticker.C
-		}
-	}()
+	// SOA retry timer in seconds
+	dnsSOARetry = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_soa_retry_seconds",
+			Help: "SOA retry interval in seconds",
+		},
+		[]string{"fqdn", "dns_server"},
+	)
+
+	// SOA expire timer in seconds
+	dnsSOAExpire = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_soa_expire_seconds",
+			Help: "SOA expire time in seconds",
+		},
+		[]string{"fqdn", "dns_server"},
+	)
+
+	// SOA minimum (negative caching) TTL in seconds
+	dnsSOAMinimumTTL = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_soa_minimum_ttl_seconds",
+			Help: "SOA minimum TTL in seconds",
+		},
+		[]string{"fqdn", "dns_server"},
+	)
+
+	// PTR records (1 = hostname exists for address)
+	dnsPTRRecord = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_ptr_record",
+			Help: "PTR records for address (1 = hostname exists)",
+		},
+		[]string{"fqdn", "dns_server", "hostname"},
+	)
+
+	// SRV records (value = priority)
+	dnsSRVRecord = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_srv_record",
+			Help: "SRV records for FQDN (value = priority)",
+		},
+		[]string{"fqdn", "dns_server", "target", "port"},
+	)
+
+	// SRV record weights
+	dnsSRVRecordWeight = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_srv_record_weight",
+			Help: "SRV record weight for FQDN",
+		},
+		[]string{"fqdn", "dns_server", "target", "port"},
+	)
+
+	// Minimum TTL in the answer section
+	dnsRecordTTL = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_record_ttl_seconds",
+			Help: "Minimum TTL of the records in the answer section in seconds",
+		},
+		[]string{"fqdn", "record_type", "dns_server"},
+	)
+
+	// Maximum TTL in the answer section
+	dnsRecordTTLMax = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_record_ttl_max_seconds",
+			Help: "Maximum TTL of the records in the answer section in seconds",
+		},
+		[]string{"fqdn", "record_type", "dns_server"},
+	)
+
+	// Rcode of the last response (-1 = no response)
+	dnsLastResponseRcode = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_last_response_rcode",
+			Help: "DNS response code of the last lookup (-1 = no response, e.g. timeout)",
+		},
+		[]string{"fqdn", "record_type", "dns_server"},
+	)
+
+	// Truncation (TC) flag of the UDP response
+	dnsResponseTruncated = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_response_truncated",
+			Help: "Whether the UDP response had the TC flag set (1 = truncated)",
+		},
+		[]string{"fqdn", "record_type", "dns_server"},
+	)
+
+	// Size of the DNS response message
+	dnsResponseSize = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_response_size_bytes",
+			Help: "Size of the last DNS response message in bytes",
+		},
+		[]string{"fqdn", "record_type", "dns_server"},
+	)
+
+	// Authoritative answer (AA) flag of the response
+	dnsResponseAuthoritative = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_response_authoritative",
+			Help: "Whether the response had the AA flag set (1 = authoritative)",
+		},
+		[]string{"fqdn", "record_type", "dns_server"},
+	)
+
+	// Recursion available (RA) flag per DNS server
+	dnsRecursionAvailable = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_recursion_available",
+			Help: "Whether all responses from the DNS server in the last cycle had the RA flag set",
+		},
+		[]string{"dns_server"},
+	)
+
+	// Configured EDNS0 UDP buffer size per DNS server
+	dnsEDNSBufferSize = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_edns_buffer_size_bytes",
+			Help: "EDNS0 UDP buffer size advertised to the DNS server (0 = EDNS0 disabled)",
+		},
+		[]string{"dns_server"},
+	)
+
+	// Configured DNS servers
+	dnsServerInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_server_info",
+			Help: "Configured DNS servers, their address and the protocol and address family they are queried over (always 1)",
+		},
+		[]string{"dns_server", "name", "address", "protocol", "transport_family"},
+	)
+
+	// NSID returned in the last response
+	dnsResponseNSIDInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_response_nsid_info",
+			Help: "Name server identifier (NSID) returned in the last response (always 1)",
+		},
+		[]string{"fqdn", "record_type", "dns_server", "nsid"},
+	)
+
+	// CHAOS class TXT answers (version.bind, hostname.bind, ...)
+	dnsServerChaosInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_server_chaos_info",
+			Help: "CHAOS class TXT answer of the DNS server (always 1)",
+		},
+		[]string{"dns_server", "query", "value"},
+	)
+
+	// Number of CNAMEs followed to reach the answer
+	dnsCNAMEChainLength = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_cname_chain_length",
+			Help: "Number of CNAME records in the answer chain",
+		},
+		[]string{"fqdn", "record_type", "dns_server"},
+	)
+
+	// HTTPS / SVCB records (1 = record exists)
+	dnsHTTPSRecord = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_https_record",
+			Help: "HTTPS / SVCB records for FQDN (1 = record exists)",
+		},
+		[]string{"fqdn", "record_type", "dns_server", "target", "priority"},
+	)
+
+	// HTTPS / SVCB parameters (1 = parameter present)
+	dnsHTTPSParam = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_https_param",
+			Help: "Parameters of HTTPS / SVCB records such as alpn, ipv4hint and ipv6hint (1 = present)",
+		},
+		[]string{"fqdn", "record_type", "dns_server", "target", "priority", "param"},
+	)
+
+	// DNS cookie support per DNS server
+	dnsServerCookieSupported = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_server_cookie_supported",
+			Help: "Whether the DNS server echoed a valid server cookie in its last response (1 = yes)",
+		},
+		[]string{"dns_server"},
+	)
+
+	// Query name case preservation (DNS 0x20)
+	dnsCasePreserved = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_case_randomization_preserved",
+			Help: "Whether the last response preserved the randomized query name case (1 = preserved)",
+		},
+		[]string{"fqdn", "dns_server"},
+	)
+
+	// Query name case mismatches (DNS 0x20)
+	dnsCaseMismatchTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_case_randomization_mismatch_total",
+			Help: "Total number of responses that did not preserve the randomized query name case",
+		},
+		[]string{"fqdn", "dns_server"},
+	)
+
+	// Resolutions of names expected to return NXDOMAIN
+	dnsUnexpectedResolutionTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_unexpected_resolution_total",
+			Help: "Total number of lookups that resolved for targets expected to return NXDOMAIN",
+		},
+		[]string{"fqdn", "record_type", "dns_server"},
+	)
+
+	// AXFR zone transfer success/failure
+	dnsAXFRSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_axfr_success",
+			Help: "AXFR zone transfer success (1 = success, 0 = failure)",
+		},
+		[]string{"zone", "dns_server"},
+	)
+
+	// AXFR zone transfer duration in seconds
+	dnsAXFRDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_axfr_duration_seconds",
+			Help: "AXFR zone transfer duration in seconds",
+		},
+		[]string{"zone", "dns_server"},
+	)
+
+	// Number of records transferred
+	dnsAXFRRecordCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_axfr_record_count",
+			Help: "Number of records in the last successful AXFR zone transfer",
+		},
+		[]string{"zone", "dns_server"},
+	)
+
+	// SOA serial seen in the transfer
+	dnsAXFRSOASerial = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_axfr_soa_serial",
+			Help: "SOA serial of the last successful AXFR zone transfer",
+		},
+		[]string{"zone", "dns_server"},
+	)
+
+	// SOA serial lag behind the highest serial across servers
+	dnsSOASerialLag = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_soa_serial_lag",
+			Help: "Difference between the highest SOA serial seen across DNS servers in the last cycle and this server's serial",
+		},
+		[]string{"fqdn", "dns_server"},
+	)
+
+	// Duration of each hop of a delegation trace
+	dnsTraceHopDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_trace_hop_duration_seconds",
+			Help: "Duration of the query to each server answering during the last delegation trace",
+		},
+		[]string{"fqdn", "zone", "server"},
+	)
+
+	// Total duration of a delegation trace
+	dnsTraceTotalDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_trace_total_duration_seconds",
+			Help: "Total duration of the last delegation trace from the root",
+		},
+		[]string{"fqdn"},
+	)
+
+	// Number of hops of a delegation trace
+	dnsTraceHops = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_trace_hops",
+			Help: "Number of servers that answered during the last delegation trace",
+		},
+		[]string{"fqdn"},
+	)
+
+	// Delegation trace success
+	dnsTraceSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_trace_success",
+			Help: "Whether the last delegation trace reached an authoritative answer (1 = success, 0 = failure)",
+		},
+		[]string{"fqdn"},
+	)
+
+	// Failed queries during delegation traces
+	dnsTraceFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_trace_failures_total",
+			Help: "Total number of failed queries during delegation traces by zone, server and error",
+		},
+		[]string{"fqdn", "zone", "server", "error"},
+	)
 
-	// Setup HTTP server with custom registry
-	http.Handle("/metrics", promhttp.HandlerFor(customRegistry, promhttp.HandlerOpts{}))
+	// Consistency of the NS records at the parent and in the zone
+	dnsDelegationConsistent = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_delegation_consistent",
+			Help: "Whether the NS set and glue at the parent zone match the zone's own NS records and addresses (1 = consistent, 0 = drift)",
+		},
+		[]string{"fqdn"},
+	)
+
+	// Delegation check success
+	dnsDelegationCheckSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_delegation_check_success",
+			Help: "Whether the last delegation check could fetch the NS records from both the parent and the zone (1 = success, 0 = failure)",
+		},
+		[]string{"fqdn"},
+	)
+
+	// Name servers missing on one side of a delegation
+	dnsDelegationMissingNSTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_delegation_missing_ns_total",
+			Help: "Total number of delegation checks where a name server was listed only at the parent or only in the zone",
+		},
+		[]string{"fqdn", "ns", "missing"},
+	)
+
+	// Glue records that differ from the authoritative addresses
+	dnsGlueMismatchTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_glue_mismatch_total",
+			Help: "Total number of delegation checks where the glue of a name server differed from its authoritative addresses",
+		},
+		[]string{"fqdn", "ns"},
+	)
+
+	// Wildcard records answering for non-existent names
+	dnsWildcardPresent = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_wildcard_present",
+			Help: "Whether a random non-existent label under the zone resolved (1 = wildcard present, 0 = NXDOMAIN or no data)",
+		},
+		[]string{"zone", "dns_server"},
+	)
+
+	// Addresses a wildcard record resolved to
+	dnsWildcardIP = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_wildcard_ip",
+			Help: "Addresses returned for a random non-existent label under the zone (1 = present)",
+		},
+		[]string{"zone", "dns_server", "ip_address"},
+	)
+
+	// Wildcard check success
+	dnsWildcardCheckSuccess = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_wildcard_check_success",
+			Help: "Whether the last wildcard check got an answer from the DNS server (1 = success, 0 = failure)",
+		},
+		[]string{"zone", "dns_server"},
+	)
+
+	// DS records at the parent zone
+	dnsDSRecord = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_ds_record",
+			Help: "DS records of the FQDN published at its parent zone (1 = present)",
+		},
+		[]string{"fqdn", "dns_server", "key_tag", "algorithm", "digest_type"},
+	)
+
+	// DNSKEY records served by the zone
+	dnsDNSKEYRecord = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_dnskey_record",
+			Help: "DNSKEY records served for the FQDN (1 = present)",
+		},
+		[]string{"fqdn", "dns_server", "key_tag", "algorithm", "flags"},
+	)
+
+	// Number of DNSKEY records
+	dnsDNSKEYCount = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_dnskey_count",
+			Help: "Number of DNSKEY records served for the FQDN",
+		},
+		[]string{"fqdn", "dns_server"},
+	)
+
+	// Both address families resolving in the same cycle
+	dnsDualStack = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_dual_stack",
+			Help: "Whether both A and AAAA lookups resolved to at least one address in the last cycle (1 = both, 0 = at least one family missing)",
+		},
+		[]string{"fqdn", "dns_server"},
+	)
+
+	// Failed QUIC handshakes with DoQ servers
+	dnsQUICHandshakeFailuresTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_quic_handshake_failures_total",
+			Help: "Total number of queries to DoQ servers that failed because the QUIC connection could not be established",
+		},
+		[]string{"dns_server"},
+	)
+
+	// Queries sent by the last lookup, including retries
+	dnsQueryAttempts = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_query_attempts",
+			Help: "Number of queries sent by the last DNS resolution, more than 1 when failed queries were retried",
+		},
+		[]string{"fqdn", "record_type", "dns_server"},
+	)
+
+	// Search name a relative target resolved as
+	dnsSearchResolvedInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_search_resolved_info",
+			Help: "Search name a relative target name resolved as in the last DNS resolution (always 1)",
+		},
+		[]string{"fqdn", "record_type", "dns_server", "resolved_fqdn"},
+	)
 
-	listenAddr := cfg.GetListenAddress()
-	log.Printf("Server starting on %s", listenAddr)
+	// Time of the last successful resolution
+	dnsLastSuccessfulResolutionTimestamp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_last_successful_resolution_timestamp_seconds",
+			Help: "Unix time of the last successful DNS resolution",
+		},
+		[]string{"fqdn", "record_type", "dns_server"},
+	)
 
-	if err := http.ListenAndServe(listenAddr, nil); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	// Failed monitoring cycles in a row
+	dnsConsecutiveFailures = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_consecutive_failures",
+			Help: "Number of consecutive monitoring cycles in which the DNS resolution failed (0 after a success)",
+		},
+		[]string{"fqdn", "record_type", "dns_server"},
+	)
+
+	// Why the last lookup failed
+	dnsLastErrorInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_last_error_info",
+			Help: "Error class of the last DNS resolution if it failed (always 1, removed on success)",
+		},
+		[]string{"fqdn", "record_type", "dns_server", "error_class"},
+	)
+
+	// Changes of the resolved address set
+	dnsResolvedIPChangesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_resolved_ip_changes_total",
+			Help: "Total number of times the set of resolved IP addresses differed from the previous successful lookup",
+		},
+		[]string{"fqdn", "record_type", "dns_server"},
+	)
+	dnsResolvedIPLastChange = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_resolved_ip_last_change_timestamp_seconds",
+			Help: "Unix time the set of resolved IP addresses last changed, or was first resolved",
+		},
+		[]string{"fqdn", "record_type", "dns_server"},
+	)
+
+	// Hash of the resolved address set
+	dnsResolvedIPSetHash = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_resolved_ip_set_hash",
+			Help: "FNV-1a hash of the sorted set of resolved IP addresses, equal for equal sets",
+		},
+		[]string{"fqdn", "record_type", "dns_server"},
+	)
+
+	// Duration of the last monitoring cycle
+	dnsMonitorCycleDuration = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "dns_monitor_cycle_duration_seconds",
+			Help: "Duration of the last monitoring cycle of the global interval in seconds",
+		},
+	)
+
+	// Monitoring cycles longer than the interval
+	dnsMonitorCycleOverrunTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "dns_monitor_cycle_overrun_total",
+			Help: "Total number of monitoring cycles that took longer than their interval",
+		},
+	)
+
+	// Cycles dropped by the skip overrun policy
+	dnsMonitorCyclesSkippedTotal = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "dns_monitor_cycles_skipped_total",
+			Help: "Total number of monitoring cycles skipped because the previous cycle of their interval overran it",
+		},
+	)
+
+	// Whether targets are probed or disabled in the configuration
+	dnsTargetEnabled = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_target_enabled",
+			Help: "Whether the target is probed (1) or disabled in the configuration (0)",
+		},
+		[]string{"fqdn"},
+	)
+
+	// Whether targets are within a maintenance window
+	dnsTargetInMaintenance = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_target_in_maintenance",
+			Help: "Whether the target is within a maintenance window (1) or not (0)",
+		},
+		[]string{"fqdn"},
+	)
+
+	// Effective probe interval per target
+	dnsTargetProbeInterval = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_target_probe_interval_seconds",
+			Help: "Interval the target is probed at in seconds",
+		},
+		[]string{"fqdn", "record_type"},
+	)
+
+	// Size of the configuration
+	dnsTargetsConfigured = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "dns_targets_configured",
+			Help: "Number of configured targets",
+		},
+	)
+	dnsServersConfigured = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "dns_servers_configured",
+			Help: "Number of configured DNS servers",
+		},
+	)
+	dnsProbeCombinations = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "dns_probe_combinations",
+			Help: "Number of target, DNS server and record type combinations resolved per cycle, client subnet variants counted separately",
+		},
+	)
+
+	// Outcome of the last configuration (re)load
+	dnsConfigLastReloadSuccessful = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "dns_config_last_reload_successful",
+			Help: "Whether the last configuration reload succeeded (1) or failed and the previous configuration is still in use (0)",
+		},
+	)
+	dnsConfigLastReloadTime = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "dns_config_last_reload_time_seconds",
+			Help: "Unix time of the last configuration reload attempt",
+		},
+	)
+
+	// Configuration in use
+	dnsConfigInfo = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_config_info",
+			Help: "SHA-256 hash of the configuration file in use (always 1)",
+		},
+		[]string{"hash"},
+	)
+
+	// Response time statistics of the queries of a probe
+	dnsResponseTimeMin = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_response_time_min_seconds",
+			Help: "Shortest response time of the queries of the last probe in seconds",
+		},
+		[]string{"fqdn", "record_type", "dns_server", "client_subnet"},
+	)
+	dnsResponseTimeMedian = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_response_time_median_seconds",
+			Help: "Median response time of the queries of the last probe in seconds",
+		},
+		[]string{"fqdn", "record_type", "dns_server", "client_subnet"},
+	)
+	dnsResponseTimeMax = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_response_time_max_seconds",
+			Help: "Longest response time of the queries of the last probe in seconds",
+		},
+		[]string{"fqdn", "record_type", "dns_server", "client_subnet"},
+	)
+
+	// Share of successful queries of a probe
+	dnsProbeSuccessRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_probe_success_ratio",
+			Help: "Share of successful queries of the last probe (0 to 1)",
+		},
+		[]string{"fqdn", "record_type", "dns_server", "client_subnet"},
+	)
+
+	// Share of timed out queries of a probe
+	dnsQueryLossRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_query_loss_ratio",
+			Help: "Share of queries of the last probe that timed out (0 to 1)",
+		},
+		[]string{"fqdn", "record_type", "dns_server"},
+	)
+
+	// Timed out DNS queries
+	dnsQueryTimeoutsTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_query_timeouts_total",
+			Help: "Total number of DNS queries that timed out",
+		},
+		[]string{"fqdn", "record_type", "dns_server"},
+	)
+
+	// Server reachability independent of the targets
+	dnsServerUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_server_up",
+			Help: "Whether the DNS server answered the health check query (1 = up, 0 = down)",
+		},
+		[]string{"dns_server"},
+	)
+
+	// Duration of the health check query
+	dnsServerHealthCheckDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_server_health_check_duration_seconds",
+			Help: "Duration of the last health check query of the DNS server in seconds",
+		},
+		[]string{"dns_server"},
+	)
+
+	// Lookups in progress
+	dnsQueriesInFlight = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "dns_queries_in_flight",
+			Help: "Number of DNS lookups currently in progress",
+		},
+	)
+
+	// Most lookups in progress at once
+	dnsQueriesInFlightMax = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "dns_queries_in_flight_max",
+			Help: "Highest number of DNS lookups in progress at once during the last monitoring cycle",
+		},
+	)
+
+	// Share of successful lookups within the availability window
+	dnsResolutionAvailabilityRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_resolution_availability_ratio",
+			Help: "Share of successful DNS lookups within the availability window (0 to 1)",
+		},
+		[]string{"fqdn", "record_type", "dns_server"},
+	)
+
+	// Moving average of the response time
+	dnsResponseTimeEWMA = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_response_time_ewma_seconds",
+			Help: "Exponentially weighted moving average of the response time of successful DNS lookups in seconds",
+		},
+		[]string{"fqdn", "record_type", "dns_server"},
+	)
+
+	// Successful lookups slower than the latency SLO of the target
+	dnsResponseSLOBreachesTotal = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "dns_response_slo_breaches_total",
+			Help: "Total number of successful DNS lookups slower than the latency SLO of the target",
+		},
+		[]string{"fqdn", "record_type", "dns_server"},
+	)
+
+	// Latency SLO of the target
+	dnsResponseSLOThreshold = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_response_slo_threshold_seconds",
+			Help: "Latency SLO of the target in seconds",
+		},
+		[]string{"fqdn", "record_type", "dns_server"},
+	)
+
+	// Whether the exported IP address series were capped
+	dnsResolvedIPTruncated = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "dns_resolved_ip_truncated",
+			Help: "Whether only part of the resolved IP addresses are exported as dns_resolved_ip_address (1 = truncated)",
+		},
+		[]string{"fqdn", "record_type", "dns_server", "client_subnet"},
+	)
+)
+
+var (
+	// Custom registry, Go runtime and process metrics are only added with
+	// --web.enable-runtime-metrics
+	customRegistry = prometheus.NewRegistry()
+)
+
+// registerMetrics registers the metrics with the custom registry (not the
+// default one) through registerer, which adds the configured constant labels
+func registerMetrics(registerer prometheus.Registerer) {
+	registerer.MustRegister(dnsResponseTime)
+	registerer.MustRegister(dnsResolutionSuccess)
+	registerer.MustRegister(dnsResolvedIpCount)
+	registerer.MustRegister(dnsQueryTotal)
+	registerer.MustRegister(dnsResolvedIpAddress)
+	registerer.MustRegister(dnsResolvedRecordCount)
+	registerer.MustRegister(dnsMXRecord)
+	registerer.MustRegister(dnsTXTRecordCount)
+	registerer.MustRegister(dnsTXTRecordsHash)
+	registerer.MustRegister(dnsNSRecord)
+	registerer.MustRegister(dnsSOASerial)
+	registerer.MustRegister(dnsSOARefresh)
+	registerer.MustRegister(dnsSOARetry)
+	registerer.MustRegister(dnsSOAExpire)
+	registerer.MustRegister(dnsSOAMinimumTTL)
+	registerer.MustRegister(dnsPTRRecord)
+	registerer.MustRegister(dnsSRVRecord)
+	registerer.MustRegister(dnsSRVRecordWeight)
+	registerer.MustRegister(dnsRecordTTL)
+	registerer.MustRegister(dnsRecordTTLMax)
+	registerer.MustRegister(dnsLastResponseRcode)
+	registerer.MustRegister(dnsResponseTruncated)
+	registerer.MustRegister(dnsResponseSize)
+	registerer.MustRegister(dnsResponseAuthoritative)
+	registerer.MustRegister(dnsRecursionAvailable)
+	registerer.MustRegister(dnsEDNSBufferSize)
+	registerer.MustRegister(dnsServerInfo)
+	registerer.MustRegister(dnsResponseNSIDInfo)
+	registerer.MustRegister(dnsServerChaosInfo)
+	registerer.MustRegister(dnsCNAMEChainLength)
+	registerer.MustRegister(dnsHTTPSRecord)
+	registerer.MustRegister(dnsHTTPSParam)
+	registerer.MustRegister(dnsServerCookieSupported)
+	registerer.MustRegister(dnsCasePreserved)
+	registerer.MustRegister(dnsCaseMismatchTotal)
+	registerer.MustRegister(dnsUnexpectedResolutionTotal)
+	registerer.MustRegister(dnsAXFRSuccess)
+	registerer.MustRegister(dnsAXFRDuration)
+	registerer.MustRegister(dnsAXFRRecordCount)
+	registerer.MustRegister(dnsAXFRSOASerial)
+	registerer.MustRegister(dnsSOASerialLag)
+	registerer.MustRegister(dnsTraceHopDuration)
+	registerer.MustRegister(dnsTraceTotalDuration)
+	registerer.MustRegister(dnsTraceHops)
+	registerer.MustRegister(dnsTraceSuccess)
+	registerer.MustRegister(dnsTraceFailuresTotal)
+	registerer.MustRegister(dnsDelegationConsistent)
+	registerer.MustRegister(dnsDelegationCheckSuccess)
+	registerer.MustRegister(dnsDelegationMissingNSTotal)
+	registerer.MustRegister(dnsGlueMismatchTotal)
+	registerer.MustRegister(dnsWildcardPresent)
+	registerer.MustRegister(dnsWildcardIP)
+	registerer.MustRegister(dnsWildcardCheckSuccess)
+	registerer.MustRegister(dnsDSRecord)
+	registerer.MustRegister(dnsDNSKEYRecord)
+	registerer.MustRegister(dnsDNSKEYCount)
+	registerer.MustRegister(dnsDualStack)
+	registerer.MustRegister(dnsQUICHandshakeFailuresTotal)
+	registerer.MustRegister(dnsQueryAttempts)
+	registerer.MustRegister(dnsSearchResolvedInfo)
+	registerer.MustRegister(dnsLastSuccessfulResolutionTimestamp)
+	registerer.MustRegister(dnsConsecutiveFailures)
+	registerer.MustRegister(dnsLastErrorInfo)
+	registerer.MustRegister(dnsResolvedIPChangesTotal)
+	registerer.MustRegister(dnsResolvedIPLastChange)
+	registerer.MustRegister(dnsResolvedIPSetHash)
+	registerer.MustRegister(dnsMonitorCycleDuration)
+	registerer.MustRegister(dnsMonitorCycleOverrunTotal)
+	registerer.MustRegister(dnsMonitorCyclesSkippedTotal)
+	registerer.MustRegister(dnsTargetProbeInterval)
+	registerer.MustRegister(dnsTargetEnabled)
+	registerer.MustRegister(dnsTargetInMaintenance)
+	registerer.MustRegister(dnsTargetsConfigured)
+	registerer.MustRegister(dnsServersConfigured)
+	registerer.MustRegister(dnsProbeCombinations)
+	registerer.MustRegister(dnsConfigLastReloadSuccessful)
+	registerer.MustRegister(dnsConfigLastReloadTime)
+	registerer.MustRegister(dnsConfigInfo)
+	registerer.MustRegister(dnsResolvedIPTruncated)
+	registerer.MustRegister(dnsResponseTimeMin)
+	registerer.MustRegister(dnsResponseTimeMedian)
+	registerer.MustRegister(dnsResponseTimeMax)
+	registerer.MustRegister(dnsProbeSuccessRatio)
+	registerer.MustRegister(dnsQueryLossRatio)
+	registerer.MustRegister(dnsQueryTimeoutsTotal)
+	registerer.MustRegister(dnsServerUp)
+	registerer.MustRegister(dnsServerHealthCheckDuration)
+	registerer.MustRegister(dnsQueriesInFlight)
+	registerer.MustRegister(dnsQueriesInFlightMax)
+	registerer.MustRegister(dnsResolutionAvailabilityRatio)
+	registerer.MustRegister(dnsResponseTimeEWMA)
+	registerer.MustRegister(dnsResponseSLOBreachesTotal)
+	registerer.MustRegister(dnsResponseSLOThreshold)
+}
+
+func main() {
+	// Parse command line flags
+	configFile := flag.String("config", "config.yaml", "Path to configuration file")
+	runtimeMetrics := flag.Bool("web.enable-runtime-metrics", false, "Also export Go runtime and process metrics")
+	checkConfig := flag.Bool("check-config", false, "Validate the configuration file, print a summary and exit")
+	printDefaultConfig := flag.Bool("print-default-config", false, "Print the default configuration with an example target and server and exit")
+	autoReload := flag.Bool("config.auto-reload", false, "Reload the configuration when the file changes")
+	expandEnv := flag.Bool("config.expand-env", false, "Replace ${VAR} and ${VAR:-default} in the configuration file with environment variables")
+	lenient := flag.Bool("config.lenient", false, "Ignore unknown settings in the configuration file instead of failing")
+	listenAddress := flag.String("web.listen-address", "", "Address to listen on as host:port, overrides server.listen_address, host and port")
+	listenPort := flag.Int("web.listen-port", 0, "Port to listen on, overrides server.port or the port of the listen address")
+	webConfigFile := flag.String("web.config.file", "", "Path to a web configuration file enabling TLS and basic authentication")
+	systemdSocket := flag.Bool("web.systemd-socket", false, "Serve on the sockets passed by systemd socket activation instead of listening")
+	var interval, timeout config.Duration
+	flag.Var(&interval, "monitor.interval", "DNS resolution interval, overrides monitoring.interval")
+	flag.Var(&timeout, "monitor.timeout", "DNS query timeout, overrides monitoring.timeout")
+	flag.Parse()
+
+	if *printDefaultConfig {
+		if err := config.WriteDefault(os.Stdout); err != nil {
+			log.Fatalf("Failed to print the default configuration: %v", err)
+		}
+		return
+	}
+
+	loadOptions := config.LoadOptions{
+		ExpandEnv:     *expandEnv,
+		Lenient:       *lenient,
+		ListenAddress: *listenAddress,
+		Port:          *listenPort,
+		Interval:      interval,
+		Timeout:       timeout,
+	}
+
+	// Load configuration
+	cfg, err := config.LoadConfig(*configFile, loadOptions)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	if *webConfigFile != "" {
+		if err := web.Validate(*webConfigFile); err != nil {
+			log.Fatalf("Failed to load web configuration: %v", err)
+		}
+	}
+
+	// Checked the way the exporter would start, including the per-server
+	// settings, but without serving or probing
+	if *checkConfig {
+		if err := printConfigSummary(cfg); err != nil {
+			log.Fatalf("Failed to load configuration: %v", err)
+		}
+		return
+	}
+
+	if *systemdSocket {
+		log.Printf("Starting DNS trace exporter on systemd sockets")
+	} else {
+		log.Printf("Starting DNS trace exporter on %s", listenDescription(cfg))
+	}
+	log.Printf("Monitoring interval: %v", cfg.Monitoring.Interval)
+	log.Printf("DNS timeout: %v", cfg.Monitoring.Timeout)
+	log.Printf("Overrun policy: %s", cfg.Monitoring.OverrunPolicy)
+	log.Printf("Runtime metrics: %v", *runtimeMetrics)
+
+	// Constant labels cannot change without re-registering every metric,
+	// so they only take effect on restart
+	registerer := prometheus.WrapRegistererWith(cfg.Server.Labels, customRegistry)
+	registerMetrics(registerer)
+	if *runtimeMetrics {
+		registerer.MustRegister(collectors.NewGoCollector())
+		registerer.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	}
+
+	resolver := newResolver(cfg, registerer)
+
+	// Resolve per-server settings
+	servers, err := buildServers(cfg)
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	applyConfig(cfg, servers)
+
+	// Reload the configuration on SIGHUP, keeping the previous one if the
+	// new one is invalid
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadConfig(*configFile, loadOptions)
+		}
+	}()
+	if *autoReload {
+		if err := watchConfig(*configFile, loadOptions); err != nil {
+			log.Fatalf("Failed to watch %s: %v", *configFile, err)
+		}
+		log.Printf("Watching %s for changes", *configFile)
+	}
+
+	// Start DNS monitoring. The targets of each interval are probed in
+	// cycles of their own, scheduled on multiples of the interval from its
+	// first cycle, so they do not drift.
+	go func() {
+		next := make(map[time.Duration]time.Time)
+		running := make(map[time.Duration]int)
+		done := make(chan time.Duration)
+		var previous *monitorConfig
+		for {
+			// The configuration is only switched between cycles, series of
+			// targets and servers it no longer has are deleted first. Cycles
+			// of other intervals may still be running with the previous one.
+			current := currentConfig.Load()
+			if previous != nil && current != previous {
+				forgetRemoved(resolver, previous, current)
+				resolver.ResetConnections()
+			}
+			previous = current
+			cfg, servers := current.cfg, current.servers
+			policy := cfg.Monitoring.OverrunPolicy
+
+			// Intervals keep their schedule across reloads, new ones start
+			// right away
+			now := time.Now()
+			wake := now.Add(time.Duration(cfg.Monitoring.Interval))
+			scheduled := make(map[time.Duration]bool)
+			for _, group := range probeGroups(cfg) {
+				scheduled[group.interval] = true
+				due, ok := next[group.interval]
+				if !ok {
+					due = now
+				}
+
+				// Overlapping cycles share the resolver, so its per-cycle
+				// aggregates then cover lookups of both
+				busy := running[group.interval] > 0 && policy != config.OverrunPolicyOverlap
+				start, nextDue, skipped := scheduleCycle(policy, group.interval, due, now, busy)
+				due = nextDue
+				if start {
+					running[group.interval]++
+					go func(group *probeGroup) {
+						runCycle(resolver, cfg, servers, group)
+						done <- group.interval
+					}(group)
+				}
+				dnsMonitorCyclesSkippedTotal.Add(float64(skipped))
+				next[group.interval] = due
+
+				// A queued cycle starts when the running one is done
+				if (!busy || policy == config.OverrunPolicySkip) && due.Before(wake) {
+					wake = due
+				}
+			}
+			for interval := range next {
+				if !scheduled[interval] {
+					delete(next, interval)
+				}
+			}
+
+			timer := time.NewTimer(time.Until(wake))
+			select {
+			case interval := <-done:
+				running[interval]--
+			case <-timer.C:
+			}
+			timer.Stop()
+		}
+	}()
+
+	// Delete the series of probes that stopped, checked at a fraction of
+	// the TTL so series live at most half the TTL longer
+	go func() {
+		for {
+			ttl := time.Duration(currentConfig.Load().cfg.Monitoring.MetricStalenessTTL)
+			if ttl <= 0 {
+				time.Sleep(time.Minute)
+				continue
+			}
+			if expired := resolver.ExpireStale(ttl); expired > 0 {
+				log.Printf("Deleted the series of %d probes not performed for %v", expired, ttl)
+			}
+			time.Sleep(max(ttl/2, time.Second))
+		}
+	}()
+
+	// Setup HTTP server with custom registry, adding the target labels on
+	// each scrape. Exemplars are only part of the OpenMetrics format, which
+	// is otherwise not offered so existing scrapes keep the text format.
+	http.Handle("/metrics", promhttp.HandlerFor(targetLabelGatherer{customRegistry}, promhttp.HandlerOpts{
+		EnableOpenMetrics: cfg.Monitoring.LatencyExemplars,
+	}))
+
+	// TCP is listened on first, so a failure does not leave a socket file.
+	// Socket activation replaces both.
+	var listeners []net.Listener
+	if cfg.ServesTCP() && !*systemdSocket {
+		listenAddr := cfg.GetListenAddress()
+		listener, err := net.Listen("tcp", listenAddr)
+		if err != nil {
+			log.Fatalf("Server failed to start: %v", err)
+		}
+		log.Printf("Server starting on %s", listenAddr)
+		listeners = append(listeners, listener)
+	}
+	if cfg.Server.SocketPath != "" && !*systemdSocket {
+		listener, err := listenUnix(cfg.Server.SocketPath, os.FileMode(cfg.Server.SocketMode))
+		if err != nil {
+			log.Fatalf("Server failed to start: %v", err)
+		}
+		log.Printf("Server starting on unix socket %s", cfg.Server.SocketPath)
+		listeners = append(listeners, listener)
+	}
+
+	// TLS and basic authentication are set up from the web configuration,
+	// plain HTTP is served without one. Shutting down closes the listeners,
+	// which removes the socket file.
+	httpServer := &http.Server{}
+	webFlags := &web.FlagConfig{
+		WebSystemdSocket: systemdSocket,
+		WebConfigFile:    webConfigFile,
+	}
+	logger := kitlog.NewLogfmtLogger(kitlog.StdlibWriter{})
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	errs := make(chan error, 1)
+	go func() {
+		if *systemdSocket {
+			errs <- web.ListenAndServe(httpServer, webFlags, logger)
+			return
+		}
+		errs <- web.ServeMultiple(listeners, httpServer, webFlags, logger)
+	}()
+	select {
+	case err := <-errs:
+		httpServer.Close()
+		log.Fatalf("Server failed: %v", err)
+	case sig := <-stop:
+		log.Printf("Received %v, shutting down", sig)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := httpServer.Shutdown(ctx); err != nil {
+			log.Printf("Shutdown failed: %v", err)
+		}
+		// DoQ servers are told the connections are closed rather than
+		// keeping them until they time out
+		resolver.ResetConnections()
+	}
+}
+
+// newResolver registers the latency metrics, which depend on the
+// configuration, and creates the resolver updating all metrics
+func newResolver(cfg *config.Config, registerer prometheus.Registerer) *dns.Resolver {
+	dnsServerResponseDuration = prometheus.NewHistogramVec(
+		latencyHistogramOpts(cfg,
+			"dns_server_response_duration_seconds",
+			"Distribution of DNS response times in seconds per DNS server, over all targets",
+		),
+		[]string{"dns_server", "record_type"},
+	)
+	registerer.MustRegister(dnsServerResponseDuration)
+	if !cfg.Monitoring.DisableFQDNLatency {
+		dnsResponseDuration = prometheus.NewHistogramVec(
+			latencyHistogramOpts(cfg,
+				"dns_response_duration_seconds",
+				"Distribution of DNS response times in seconds",
+			),
+			[]string{"fqdn", "record_type", "dns_server", "client_subnet"},
+		)
+		registerer.MustRegister(dnsResponseDuration)
+	}
+	if len(cfg.Monitoring.LatencyQuantiles) > 0 && !cfg.Monitoring.DisableFQDNLatency {
+		dnsResponseDurationQuantiles = prometheus.NewSummaryVec(
+			prometheus.SummaryOpts{
+				Name:       "dns_response_duration_quantiles",
+				Help:       "Quantiles of DNS response times in seconds over the configured window",
+				Objectives: cfg.Monitoring.LatencyQuantiles,
+				MaxAge:     time.Duration(cfg.Monitoring.LatencyQuantilesMaxAge),
+			},
+			[]string{"fqdn", "record_type", "dns_server"},
+		)
+		registerer.MustRegister(dnsResponseDurationQuantiles)
+	}
+	responseTime := dnsResponseTime
+	if cfg.Monitoring.LatencyHistogramOnly || cfg.Monitoring.DisableFQDNLatency {
+		registerer.Unregister(dnsResponseTime)
+		responseTime = nil
+	}
+
+	return dns.NewResolver(dns.Metrics{
+		ResponseTime:              responseTime,
+		ResponseDuration:          dnsResponseDuration,
+		ResponseDurationQuantiles: dnsResponseDurationQuantiles,
+		ServerResponseDuration:    dnsServerResponseDuration,
+		ResponseTimeMin:           dnsResponseTimeMin,
+		ResponseTimeMedian:        dnsResponseTimeMedian,
+		ResponseTimeMax:           dnsResponseTimeMax,
+		ProbeSuccessRatio:         dnsProbeSuccessRatio,
+		QueryLossRatio:            dnsQueryLossRatio,
+		LastSuccessTimestamp:      dnsLastSuccessfulResolutionTimestamp,
+		ConsecutiveFailures:       dnsConsecutiveFailures,
+		LastErrorInfo:             dnsLastErrorInfo,
+		ResolvedIPChangesTotal:    dnsResolvedIPChangesTotal,
+		ResolvedIPLastChange:      dnsResolvedIPLastChange,
+		ResolvedIPSetHash:         dnsResolvedIPSetHash,
+		ResolutionSuccess:         dnsResolutionSuccess,
+		ResolvedIpCount:           dnsResolvedIpCount,
+		QueryTotal:                dnsQueryTotal,
+		QueryTimeoutsTotal:        dnsQueryTimeoutsTotal,
+		ServerUp:                  dnsServerUp,
+		HealthCheckDuration:       dnsServerHealthCheckDuration,
+		QueriesInFlight:           dnsQueriesInFlight,
+		QueriesInFlightMax:        dnsQueriesInFlightMax,
+		AvailabilityRatio:         dnsResolutionAvailabilityRatio,
+		ResponseTimeEWMA:          dnsResponseTimeEWMA,
+		SLOBreachesTotal:          dnsResponseSLOBreachesTotal,
+		SLOThreshold:              dnsResponseSLOThreshold,
+		ResolvedIpAddress:         dnsResolvedIpAddress,
+		ResolvedIPTruncated:       dnsResolvedIPTruncated,
+		ResolvedRecordCount:       dnsResolvedRecordCount,
+		MXRecord:                  dnsMXRecord,
+		TXTRecordCount:            dnsTXTRecordCount,
+		TXTRecordsHash:            dnsTXTRecordsHash,
+		NSRecord:                  dnsNSRecord,
+		SOASerial:                 dnsSOASerial,
+		SOARefresh:                dnsSOARefresh,
+		SOARetry:                  dnsSOARetry,
+		SOAExpire:                 dnsSOAExpire,
+		SOAMinimumTTL:             dnsSOAMinimumTTL,
+		PTRRecord:                 dnsPTRRecord,
+		SRVRecord:                 dnsSRVRecord,
+		SRVRecordWeight:           dnsSRVRecordWeight,
+		RecordTTL:                 dnsRecordTTL,
+		RecordTTLMax:              dnsRecordTTLMax,
+		LastResponseRcode:         dnsLastResponseRcode,
+		ResponseTruncated:         dnsResponseTruncated,
+		ResponseSize:              dnsResponseSize,
+		ResponseAuthoritative:     dnsResponseAuthoritative,
+		RecursionAvailable:        dnsRecursionAvailable,
+		ResponseNSIDInfo:          dnsResponseNSIDInfo,
+		ServerChaosInfo:           dnsServerChaosInfo,
+		CNAMEChainLength:          dnsCNAMEChainLength,
+		HTTPSRecord:               dnsHTTPSRecord,
+		HTTPSParam:                dnsHTTPSParam,
+		ServerCookieSupported:     dnsServerCookieSupported,
+		CasePreserved:             dnsCasePreserved,
+		CaseMismatchTotal:         dnsCaseMismatchTotal,
+		UnexpectedResolutionTotal: dnsUnexpectedResolutionTotal,
+		AXFRSuccess:               dnsAXFRSuccess,
+		AXFRDuration:              dnsAXFRDuration,
+		AXFRRecordCount:           dnsAXFRRecordCount,
+		AXFRSOASerial:             dnsAXFRSOASerial,
+		SOASerialLag:              dnsSOASerialLag,
+		TraceHopDuration:          dnsTraceHopDuration,
+		TraceTotalDuration:        dnsTraceTotalDuration,
+		TraceHops:                 dnsTraceHops,
+		TraceSuccess:              dnsTraceSuccess,
+		TraceFailuresTotal:        dnsTraceFailuresTotal,
+		DelegationConsistent:      dnsDelegationConsistent,
+		DelegationCheckSuccess:    dnsDelegationCheckSuccess,
+		DelegationMissingNSTotal:  dnsDelegationMissingNSTotal,
+		GlueMismatchTotal:         dnsGlueMismatchTotal,
+		WildcardPresent:           dnsWildcardPresent,
+		WildcardIP:                dnsWildcardIP,
+		WildcardCheckSuccess:      dnsWildcardCheckSuccess,
+		DSRecord:                  dnsDSRecord,
+		DNSKEYRecord:              dnsDNSKEYRecord,
+		DNSKEYCount:               dnsDNSKEYCount,
+		DualStack:                 dnsDualStack,
+		QUICHandshakeFailures:     dnsQUICHandshakeFailuresTotal,
+		QueryAttempts:             dnsQueryAttempts,
+		SearchResolvedInfo:        dnsSearchResolvedInfo,
+	})
+}
+
+// listenDescription describes where the metrics are served for logging
+func listenDescription(cfg *config.Config) string {
+	var where []string
+	if cfg.ServesTCP() {
+		where = append(where, cfg.GetListenAddress())
+	}
+	if cfg.Server.SocketPath != "" {
+		where = append(where, "unix socket "+cfg.Server.SocketPath)
+	}
+	return strings.Join(where, " and ")
+}
+
+// listenUnix listens on a unix socket at path, with the permissions of mode
+// unless it is 0. A socket file left behind by a previous run is removed,
+// one another process still accepts connections on is not.
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s is in use by another process", path)
+		}
+		log.Printf("Removing stale socket %s", path)
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			listener.Close()
+			return nil, fmt.Errorf("set mode of %s: %w", path, err)
+		}
+	}
+	return listener, nil
+}
+
+// monitorConfig is the configuration the monitoring loop runs with. It is
+// replaced as a whole when the configuration is reloaded.
+type monitorConfig struct {
+	cfg     *config.Config
+	servers []dns.Server
+}
+
+// currentConfig is the configuration of the next monitoring cycle
+var currentConfig atomic.Pointer[monitorConfig]
+
+// scheduleCycle decides on the cycle of an interval due at due, busy while
+// a cycle of the interval is still running. It reports whether a cycle
+// starts now, when the next one is due and how many cycles were skipped.
+// With the skip policy cycles that became due during an overrun are
+// dropped and the next cycle waits for the following tick, with the queue
+// policy they run back to back once the overrunning cycle is done.
+func scheduleCycle(policy string, interval time.Duration, due, now time.Time, busy bool) (start bool, next time.Time, skipped int) {
+	if !due.After(now) && !busy {
+		start = true
+		due = due.Add(interval)
+	}
+	if late := now.Sub(due); late >= 0 && policy == config.OverrunPolicySkip {
+		skipped = int(late/interval) + 1
+		due = due.Add(time.Duration(skipped) * interval)
+	}
+	return start, due, skipped
+}
+
+// runCycle probes the targets of group once against servers and, for the
+// group of the global interval, runs the per-server and zone checks
+func runCycle(resolver *dns.Resolver, cfg *config.Config, servers []dns.Server, group *probeGroup) {
+	cycleStart := time.Now()
+	// Queries to the configured servers use the server's timeout, traces
+	// and delegation checks query other servers and use the global one
+	timeout := time.Duration(cfg.Monitoring.Timeout)
+
+	// With jitter, the targets are probed in random order at random offsets
+	// from the scheduled start, the cycle starting with the first of them
+	targets, offsets := jitterTargets(group.targets, cfg.GetJitter(group.interval))
+	if len(offsets) > 0 {
+		time.Sleep(offsets[0])
+	} else if jitter := cfg.GetJitter(group.interval); jitter > 0 {
+		time.Sleep(mrand.N(jitter))
+	}
+
+	// Checked first, so dns_server_up reflects the servers' state
+	// while the targets are probed. Server and zone checks run with the
+	// cycles of the global interval only.
+	if group.global {
+		for _, server := range servers {
+			if server.System() {
+				continue
+			}
+			if err := resolver.CheckHealth(server, server.Timeout); err != nil {
+				log.Printf("Health check of %s (%s) failed: %v", server.Name, server.Label(), err)
+			}
+		}
+	}
+
+	for i, target := range targets {
+		time.Sleep(time.Until(cycleStart.Add(offsets[i])))
+
+		// Checked when the target's turn comes, so lookups already in
+		// flight when a window starts complete and are recorded as usual
+		inMaintenance, suppress := cfg.InMaintenance(target, time.Now())
+		dnsTargetInMaintenance.WithLabelValues(target.FQDN).Set(boolToFloat(inMaintenance))
+		if suppress {
+			log.Printf("Skipping %s, it is in a maintenance window", target.FQDN)
+			continue
+		}
+
+		// Each client subnet is queried as a separate variant of the target
+		subnets := target.ClientSubnets
+		if len(subnets) == 0 {
+			subnets = []string{""}
+		}
+
+		for _, subnet := range subnets {
+			for _, server := range servers {
+				if !target.QueriesServer(server.Name, server.Job, server.Tags) {
+					continue
+				}
+				for _, recordType := range target.RecordTypes {
+					var traceID string
+					if cfg.Monitoring.LatencyExemplars {
+						traceID = newTraceID()
+						log.Printf("Resolving %s (%s) via %s (%s), trace %s", target.FQDN, recordType, server.Name, server.Label(), traceID)
+					} else {
+						log.Printf("Resolving %s (%s) via %s (%s)", target.FQDN, recordType, server.Name, server.Label())
+					}
+					resolver.Probe(dns.Query{
+						FQDN:           target.FQDN,
+						Name:           target.QueryName(),
+						SearchNames:    cfg.SearchNames(target),
+						RecordType:     recordType,
+						ClientSubnet:   subnet,
+						MaxCNAMEDepth:  cfg.Monitoring.MaxCNAMEDepth,
+						MaxExportedIPs: cfg.GetMaxExportedIPs(target),
+						RandomizeCase:  cfg.GetCaseRandomization(target),
+						ExpectNXDomain: target.Expect == config.ExpectNXDomain,
+						ParentZone:     target.ParentZone,
+
+						AvailabilityWindow: time.Duration(cfg.Monitoring.AvailabilityWindow),
+						EWMAAlpha:          cfg.Monitoring.ResponseTimeEWMAAlpha,
+						LatencySLO:         time.Duration(target.LatencySLO),
+						Retries:            cfg.GetRetries(target),
+						RetryBackoff:       cfg.GetRetryBackoff(target),
+						RetryOn:            cfg.Monitoring.RetryOn,
+						Class:              target.QueryClass(),
+						TraceID:            traceID,
+					}, server, server.Timeout, cfg.GetQueriesPerProbe(target))
+				}
+			}
+		}
+
+		if target.Trace {
+			log.Printf("Tracing %s", target.FQDN)
+			err := resolver.Trace(dns.Trace{
+				FQDN:          target.FQDN,
+				Name:          target.QueryName(),
+				StartZone:     target.TraceStartZone,
+				StartServers:  target.TraceStartServers,
+				SourceAddress: cfg.Monitoring.SourceAddress,
+			}, timeout)
+			if err != nil {
+				log.Printf("Trace of %s failed: %v", target.FQDN, err)
+			}
+		}
+
+		if target.CheckDelegation {
+			log.Printf("Checking delegation of %s", target.FQDN)
+			err := resolver.CheckDelegation(dns.Delegation{
+				Zone:          target.FQDN,
+				Name:          target.QueryName(),
+				StartZone:     target.TraceStartZone,
+				StartServers:  target.TraceStartServers,
+				SourceAddress: cfg.Monitoring.SourceAddress,
+			}, timeout)
+			if err != nil {
+				log.Printf("Delegation check of %s failed: %v", target.FQDN, err)
+			}
+		}
+
+		if target.WildcardCheck {
+			for _, server := range servers {
+				if server.System() || !target.QueriesServer(server.Name, server.Job, server.Tags) {
+					continue
+				}
+				log.Printf("Checking %s for wildcards via %s (%s)", target.FQDN, server.Name, server.Label())
+				if err := resolver.CheckWildcard(target.FQDN, target.QueryName(), server, server.Timeout); err != nil {
+					log.Printf("Wildcard check of %s via %s failed: %v", target.FQDN, server.Name, err)
+				}
+			}
+		}
+	}
+	if group.global {
+		for _, server := range servers {
+			if server.System() {
+				continue
+			}
+			for _, name := range cfg.Monitoring.ChaosQueries {
+				log.Printf("Querying CHAOS %s via %s (%s)", name, server.Name, server.Label())
+				if err := resolver.LookupChaos(server, name, server.Timeout); err != nil {
+					log.Printf("CHAOS query %s via %s failed: %v", name, server.Name, err)
+				}
+			}
+		}
+		for _, zt := range cfg.ZoneTransfers {
+			log.Printf("Transferring zone %s from %s", zt.Zone, zt.Server)
+			err := resolver.Transfer(dns.ZoneTransfer{
+				Zone:           zt.Zone,
+				Server:         dns.Server{Address: zt.Server, SourceAddress: cfg.Monitoring.SourceAddress},
+				TSIGKeyName:    zt.TSIGKeyName,
+				TSIGAlgorithm:  zt.TSIGAlgorithm,
+				TSIGSecret:     zt.TSIGSecret,
+				TSIGSecretFile: zt.TSIGSecretFile,
+			}, time.Duration(zt.Timeout))
+			if err != nil {
+				log.Printf("Zone transfer of %s from %s failed: %v", zt.Zone, zt.Server, err)
+			}
+		}
+	}
+	resolver.EndCycle()
+
+	// A cycle longer than the interval is an overrun, what happens to the
+	// cycles due in the meantime depends on the overrun policy
+	cycleDuration := time.Since(cycleStart)
+	if group.global {
+		dnsMonitorCycleDuration.Set(cycleDuration.Seconds())
+	}
+	if cycleDuration > group.interval {
+		dnsMonitorCycleOverrunTotal.Inc()
+		log.Printf("Monitoring cycle took %v, longer than the interval of %v", cycleDuration, group.interval)
+	}
+}
+
+// jitterTargets returns targets in random order with the offsets from the
+// start of the cycle they are probed at, increasing random durations below
+// jitter. Without jitter the order is kept and the offsets are 0. The random
+// source is seeded per process, so exporters started together spread out.
+func jitterTargets(targets []config.Target, jitter time.Duration) ([]config.Target, []time.Duration) {
+	offsets := make([]time.Duration, len(targets))
+	if jitter <= 0 {
+		return targets, offsets
+	}
+	targets = slices.Clone(targets)
+	mrand.Shuffle(len(targets), func(i, j int) {
+		targets[i], targets[j] = targets[j], targets[i]
+	})
+	for i := range offsets {
+		offsets[i] = mrand.N(jitter)
+	}
+	slices.Sort(offsets)
+	return targets, offsets
+}
+
+// probeGroup is a cycle's share of the configuration, the targets probed
+// at the same interval. The cycles of the global interval also run the
+// server health checks, CHAOS queries and zone transfers.
+type probeGroup struct {
+	interval time.Duration
+	targets  []config.Target
+	global   bool
+}
+
+// probeGroups splits the targets of cfg by interval. The group of the
+// global interval comes first and exists even without targets.
+func probeGroups(cfg *config.Config) []*probeGroup {
+	global := &probeGroup{interval: time.Duration(cfg.Monitoring.Interval), global: true}
+	groups := []*probeGroup{global}
+	byInterval := map[time.Duration]*probeGroup{global.interval: global}
+	for _, target := range cfg.Targets {
+		if !target.IsEnabled() {
+			continue
+		}
+		interval := cfg.GetInterval(target)
+		group, ok := byInterval[interval]
+		if !ok {
+			group = &probeGroup{interval: interval}
+			byInterval[interval] = group
+			groups = append(groups, group)
+		}
+		group.targets = append(group.targets, target)
+	}
+	return groups
+}
+
+// latencyHistogramOpts returns the options of a response duration histogram
+// with the configured buckets. With native histograms enabled the histogram
+// also gets exponential buckets, which Prometheus scrapes instead of the
+// classic ones when it has native histograms enabled.
+func latencyHistogramOpts(cfg *config.Config, name, help string) prometheus.HistogramOpts {
+	opts := prometheus.HistogramOpts{
+		Name:    name,
+		Help:    help,
+		Buckets: cfg.Monitoring.LatencyBuckets,
+	}
+	if cfg.Monitoring.NativeHistograms {
+		opts.NativeHistogramBucketFactor = 1.1
+		opts.NativeHistogramMaxBucketNumber = 100
+		opts.NativeHistogramMinResetDuration = time.Hour
+	}
+	return opts
+}
+
+// newTraceID returns a random W3C trace ID identifying one probe
+func newTraceID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// buildServers resolves the per-server settings of cfg
+func buildServers(cfg *config.Config) ([]dns.Server, error) {
+	servers := make([]dns.Server, 0, len(cfg.DNSServers))
+	for _, dnsServer := range cfg.DNSServers {
+		server := dns.Server{
+			Name:           dnsServer.Name,
+			Address:        dnsServer.Address,
+			EDNSBufferSize: cfg.GetEDNSBufferSize(dnsServer),
+			NSID:           cfg.GetNSID(dnsServer),
+			Cookies:        cfg.GetCookies(dnsServer),
+			Protocol:       dnsServer.Protocol,
+
+			DoQFreshConnection: dnsServer.DoQFreshConnection,
+			SourceAddress:      cfg.GetSourceAddress(dnsServer),
+			ProxyURL:           cfg.GetProxyURL(dnsServer),
+			TransportFamily:    dnsServer.TransportFamily,
+			Headers:            dnsServer.Headers,
+			BearerTokenFile:    dnsServer.BearerTokenFile,
+			HeaderFiles:        dnsServer.HeaderFiles,
+			LabelByName:        cfg.Monitoring.DNSServerLabel == config.DNSServerLabelName,
+			HealthCheckQuery:   cfg.GetHealthCheckQuery(dnsServer),
+			Timeout:            cfg.GetTimeout(dnsServer),
+			Job:                dnsServer.Job,
+			Tags:               dnsServer.Tags,
+		}
+		if server.Protocol == "" {
+			server.Protocol = dns.ProtocolDo53
+		}
+		if server.TransportFamily == "" {
+			server.TransportFamily = dns.FamilyAny
+		}
+		if server.Protocol == dns.ProtocolDoT || server.Protocol == dns.ProtocolDoQ || server.Protocol == dns.ProtocolDoH {
+			var err error
+			server.TLSConfig, err = cfg.GetTLSConfig(dnsServer)
+			if err != nil {
+				return nil, fmt.Errorf("DNS server %s: %w", server.Name, err)
+			}
+			if server.TLSConfig.InsecureSkipVerify {
+				log.Printf("DNS server %s: TLS certificate verification is disabled", server.Name)
+			}
+		}
+		servers = append(servers, server)
+	}
+	return servers, nil
+}
+
+// applyConfig makes cfg and servers the configuration of the next cycle
+// and updates the metrics describing the configuration. The listen port,
+// constant labels and latency histogram and summary settings only take
+// effect on restart.
+func applyConfig(cfg *config.Config, servers []dns.Server) {
+	currentConfig.Store(&monitorConfig{cfg: cfg, servers: servers})
+	for _, warning := range cfg.Warnings() {
+		log.Printf("Warning: %s", warning)
+	}
+
+	dnsEDNSBufferSize.Reset()
+	dnsServerInfo.Reset()
+	for _, server := range servers {
+		log.Printf("DNS server %s (%s): EDNS buffer size %d, timeout %v", server.Name, server.Label(), server.EDNSBufferSize, server.Timeout)
+		dnsEDNSBufferSize.WithLabelValues(server.Label()).Set(float64(server.EDNSBufferSize))
+		dnsServerInfo.WithLabelValues(server.Label(), server.Name, server.AddressLabel(), server.Protocol, server.TransportFamily).Set(1)
+	}
+	for _, target := range cfg.Targets {
+		if target.Spelling() != target.FQDN {
+			log.Printf("Target %q is monitored as %s", target.Spelling(), target.FQDN)
+		}
+		if target.QueryName() != target.FQDN {
+			log.Printf("Target %s is queried as %s", target.FQDN, target.QueryName())
+		}
+		if !target.IsEnabled() {
+			log.Printf("Target %s is disabled", target.FQDN)
+		}
+	}
+	for _, job := range cfg.Jobs {
+		log.Printf("Job %s: %d targets, %d DNS servers", job.Name, len(job.Targets), len(job.DNSServers))
+	}
+	for _, group := range probeGroups(cfg)[1:] {
+		names := make([]string, 0, len(group.targets))
+		for _, target := range group.targets {
+			names = append(names, target.FQDN)
+		}
+		log.Printf("Probing every %v: %s", group.interval, strings.Join(names, ", "))
+	}
+	updateConfiguredMetrics(cfg, servers)
+
+	dnsConfigInfo.Reset()
+	dnsConfigInfo.WithLabelValues(cfg.Hash).Set(1)
+	dnsConfigLastReloadSuccessful.Set(1)
+	dnsConfigLastReloadTime.SetToCurrentTime()
+}
+
+// reloadMu serializes reloads triggered by SIGHUP and by file changes
+var reloadMu sync.Mutex
+
+// reloadConfig loads the configuration file again. An invalid configuration
+// is reported and the previous one stays in use.
+func reloadConfig(filename string, opts config.LoadOptions) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	log.Printf("Reloading configuration from %s", filename)
+	cfg, err := config.LoadConfig(filename, opts)
+	if err == nil {
+		var servers []dns.Server
+		servers, err = buildServers(cfg)
+		if err == nil {
+			applyConfig(cfg, servers)
+			return
+		}
+	}
+	log.Printf("Failed to reload configuration, keeping the previous one: %v", err)
+	dnsConfigLastReloadSuccessful.Set(0)
+	dnsConfigLastReloadTime.SetToCurrentTime()
+}
+
+// configReloadDebounce is how long the configuration file must be left
+// alone after a change before it is reloaded
+const configReloadDebounce = time.Second
+
+// watchConfig reloads the configuration when its file changes. The
+// directories of the file and of the file it is a symlink to are watched
+// rather than the file, so the file being replaced is noticed too, e.g. by
+// an editor or by Kubernetes swapping the ..data symlink a ConfigMap's
+// files point to. A burst of events results in a single reload once the
+// file was left alone for configReloadDebounce.
+func watchConfig(filename string, opts config.LoadOptions) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	filename = filepath.Clean(filename)
+	if err := watcher.Add(filepath.Dir(filename)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	// A changed symlink target shows up as events on the symlinks only, so
+	// the file it resolves to is compared as well
+	target, _ := filepath.EvalSymlinks(filename)
+	follow := func(current string) {
+		if target != "" && filepath.Dir(target) != filepath.Dir(filename) {
+			watcher.Remove(filepath.Dir(target))
+		}
+		target = current
+		if target != "" && filepath.Dir(target) != filepath.Dir(filename) {
+			if err := watcher.Add(filepath.Dir(target)); err != nil {
+				log.Printf("Watching %s failed: %v", target, err)
+			}
+		}
+	}
+	follow(target)
+
+	go func() {
+		var pending <-chan time.Time
+		for {
+			select {
+			case event := <-watcher.Events:
+				if event.Op == fsnotify.Chmod {
+					continue
+				}
+				current, _ := filepath.EvalSymlinks(filename)
+				if current != target {
+					follow(current)
+				} else if event.Name != filename && event.Name != target {
+					continue
+				}
+				pending = time.After(configReloadDebounce)
+			case err := <-watcher.Errors:
+				log.Printf("Watching %s failed: %v", filename, err)
+			case <-pending:
+				pending = nil
+				reloadConfig(filename, opts)
+			}
+		}
+	}()
+	return nil
+}
+
+// forgetRemoved deletes the series of the targets, record types, DNS servers
+// and zone transfers that are in previous but not in current
+func forgetRemoved(resolver *dns.Resolver, previous, current *monitorConfig) {
+	fqdns := make(map[string]bool)
+	queried := make(map[string]bool)
+	zones := make(map[string]bool)
+	for _, target := range current.cfg.Targets {
+		if !target.IsEnabled() {
+			continue
+		}
+		fqdns[target.FQDN] = true
+		for _, recordType := range target.RecordTypes {
+			queried[target.FQDN+"|"+recordType] = true
+		}
+	}
+	for _, zt := range current.cfg.ZoneTransfers {
+		zones[zt.Zone+"|"+zt.Server] = true
+	}
+	labels := make(map[string]bool)
+	for _, server := range current.servers {
+		labels[server.Label()] = true
+	}
+
+	for _, target := range previous.cfg.Targets {
+		if !target.IsEnabled() {
+			continue
+		}
+		if !fqdns[target.FQDN] {
+			log.Printf("Target %s was removed or disabled, deleting its metrics", target.FQDN)
+			resolver.Forget(prometheus.Labels{"fqdn": target.FQDN})
+			// Wildcard checks label the target as a zone
+			for _, server := range previous.servers {
+				if !zones[target.FQDN+"|"+server.Address] {
+					resolver.Forget(prometheus.Labels{"zone": target.FQDN, "dns_server": server.Label()})
+				}
+			}
+			continue
+		}
+		for _, recordType := range target.RecordTypes {
+			if !queried[target.FQDN+"|"+recordType] {
+				log.Printf("Target %s is no longer queried for %s, deleting its metrics", target.FQDN, recordType)
+				resolver.Forget(prometheus.Labels{"fqdn": target.FQDN, "record_type": recordType})
+			}
+		}
+	}
+	for _, server := range previous.servers {
+		if !labels[server.Label()] {
+			log.Printf("DNS server %s (%s) was removed, deleting its metrics", server.Name, server.Label())
+			resolver.Forget(prometheus.Labels{"dns_server": server.Label()})
+		}
+	}
+	for _, zt := range previous.cfg.ZoneTransfers {
+		if !zones[zt.Zone+"|"+zt.Server] {
+			log.Printf("Zone transfer of %s from %s was removed, deleting its metrics", zt.Zone, zt.Server)
+			resolver.Forget(prometheus.Labels{"zone": zt.Zone, "dns_server": zt.Server})
+		}
+	}
+}
+
+// updateConfiguredMetrics exposes the size of the configuration
+func updateConfiguredMetrics(cfg *config.Config, servers []dns.Server) {
+	dnsTargetsConfigured.Set(float64(len(cfg.Targets)))
+	dnsServersConfigured.Set(float64(len(servers)))
+	dnsProbeCombinations.Set(float64(probeCombinations(cfg, servers)))
+
+	dnsTargetProbeInterval.Reset()
+	enabled := make(map[string]bool)
+	for _, target := range cfg.Targets {
+		// A name is enabled when any of its targets is
+		enabled[target.FQDN] = enabled[target.FQDN] || target.IsEnabled()
+		if !target.IsEnabled() {
+			continue
+		}
+		for _, recordType := range target.RecordTypes {
+			dnsTargetProbeInterval.WithLabelValues(target.FQDN, recordType).Set(cfg.GetInterval(target).Seconds())
+		}
+	}
+	dnsTargetEnabled.Reset()
+	for fqdn, on := range enabled {
+		dnsTargetEnabled.WithLabelValues(fqdn).Set(boolToFloat(on))
+	}
+
+	// Updated by each cycle from then on
+	dnsTargetInMaintenance.Reset()
+	for _, target := range cfg.Targets {
+		if target.IsEnabled() {
+			inMaintenance, _ := cfg.InMaintenance(target, time.Now())
+			dnsTargetInMaintenance.WithLabelValues(target.FQDN).Set(boolToFloat(inMaintenance))
+		}
+	}
+}
+
+// boolToFloat converts a flag to a gauge value
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// probeCombinations returns the number of lookups of all cycles, one per
+// target, client subnet variant, record type and server the target is
+// queried against
+func probeCombinations(cfg *config.Config, servers []dns.Server) int {
+	combinations := 0
+	for _, target := range cfg.Targets {
+		if !target.IsEnabled() {
+			continue
+		}
+		variants := max(len(target.ClientSubnets), 1)
+		for _, server := range servers {
+			if target.QueriesServer(server.Name, server.Job, server.Tags) {
+				combinations += variants * len(target.RecordTypes)
+			}
+		}
+	}
+	return combinations
+}
+
+// printConfigSummary resolves the per-server settings of cfg like startup
+// does and prints what the exporter would monitor
+func printConfigSummary(cfg *config.Config) error {
+	servers, err := buildServers(cfg)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Configuration OK (sha256 %s)\n", cfg.Hash)
+	fmt.Printf("  Jobs:               %d\n", len(cfg.Jobs))
+	fmt.Printf("  Targets:            %d\n", len(cfg.Targets))
+	fmt.Printf("  DNS servers:        %d\n", len(servers))
+	fmt.Printf("  Probe combinations: %d\n", probeCombinations(cfg, servers))
+	fmt.Printf("  Interval:           %v\n", cfg.Monitoring.Interval)
+	fmt.Printf("  Timeout:            %v\n", cfg.Monitoring.Timeout)
+	for _, warning := range cfg.Warnings() {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+	return nil
+}
+
+// targetLabelGatherer adds the labels of the targets to every metric of a
+// target, identified by its fqdn label or, for zone checks, its zone label.
+// The labels are taken from the current configuration on each scrape, so
+// they follow reloads without re-registering the metrics.
+type targetLabelGatherer struct {
+	prometheus.Gatherer
+}
+
+func (g targetLabelGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	current := currentConfig.Load()
+	if current == nil {
+		return families, err
+	}
+	labels, names := current.cfg.TargetLabels()
+	if len(names) == 0 {
+		return families, err
+	}
+
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			target, ok := labelValue(metric, "fqdn")
+			if !ok {
+				target, ok = labelValue(metric, "zone")
+			}
+			if !ok {
+				// All metrics of a family have the same label names
+				break
+			}
+			for _, name := range names {
+				value := labels[target][name]
+				metric.Label = append(metric.Label, &dto.LabelPair{Name: &name, Value: &value})
+			}
+			slices.SortFunc(metric.Label, func(a, b *dto.LabelPair) int {
+				return strings.Compare(a.GetName(), b.GetName())
+			})
+		}
+	}
+	return families, err
+}
+
+// labelValue returns the value of the label name of metric, if it has one
+func labelValue(metric *dto.Metric, name string) (string, bool) {
+	for _, label := range metric.Label {
+		if label.GetName() == name {
+			return label.GetValue(), true
+		}
 	}
+	return "", false
 }
@@ -0,0 +1,581 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/quic-go/quic-go"
+	"gopkg.in/yaml.v2"
+
+	"github.com/ys3669/dns-track-expoter/config"
+	"github.com/ys3669/dns-track-expoter/dns"
+)
+
+// newTestResolver registers the metrics with a registry of their own and
+// creates a resolver updating them. The metric vectors are shared by all
+// tests, which therefore query names of their own.
+func newTestResolver(t *testing.T, cfg *config.Config) (*dns.Resolver, *prometheus.Registry) {
+	t.Helper()
+	registry := prometheus.NewRegistry()
+	registerMetrics(registry)
+	return newResolver(cfg, registry), registry
+}
+
+// startTestServer serves handler on a UDP port of the loopback interface
+// and returns a server to query it
+func startTestServer(t *testing.T, handler mdns.HandlerFunc) dns.Server {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := &mdns.Server{PacketConn: conn, Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return dns.Server{Name: "test", Address: conn.LocalAddr().String(), Protocol: dns.ProtocolUDP}
+}
+
+// startDoQServer serves handler over DoQ on a UDP port of the loopback
+// interface, with the test certificate of net/http/httptest, and returns a
+// server trusting it
+func startDoQServer(t *testing.T, handler func(req *mdns.Msg) *mdns.Msg) dns.Server {
+	t.Helper()
+	https := httptest.NewTLSServer(nil)
+	t.Cleanup(https.Close)
+	roots := x509.NewCertPool()
+	roots.AddCert(https.Certificate())
+
+	listener, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: https.TLS.Certificates,
+		NextProtos:   []string{"doq"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	serve := func(stream *quic.Stream) {
+		defer stream.Close()
+		var length [2]byte
+		if _, err := io.ReadFull(stream, length[:]); err != nil {
+			return
+		}
+		raw := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(stream, raw); err != nil {
+			return
+		}
+		req := new(mdns.Msg)
+		if err := req.Unpack(raw); err != nil {
+			return
+		}
+		packed, err := handler(req).Pack()
+		if err != nil {
+			return
+		}
+		stream.Write(binary.BigEndian.AppendUint16(nil, uint16(len(packed))))
+		stream.Write(packed)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			go func() {
+				for {
+					stream, err := conn.AcceptStream(context.Background())
+					if err != nil {
+						return
+					}
+					go serve(stream)
+				}
+			}()
+		}
+	}()
+	return dns.Server{
+		Name:      "doq",
+		Address:   listener.Addr().String(),
+		Protocol:  dns.ProtocolDoQ,
+		TLSConfig: &tls.Config{RootCAs: roots, ServerName: "example.com"},
+	}
+}
+
+// loadTestConfig loads the configuration content from a temporary file
+func loadTestConfig(t *testing.T, content string) *config.Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadConfig(path, config.LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// discardLog silences the log output of the test
+func discardLog(t *testing.T) {
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	log.SetOutput(io.Discard)
+}
+
+// answerAll answers every question with a record of its type, an empty
+// answer for the types other than A, AAAA and TXT
+func answerAll(w mdns.ResponseWriter, req *mdns.Msg) {
+	resp := new(mdns.Msg)
+	resp.SetReply(req)
+	question := req.Question[0]
+	header := mdns.RR_Header{Name: question.Name, Rrtype: question.Qtype, Class: mdns.ClassINET, Ttl: 60}
+	switch question.Qtype {
+	case mdns.TypeA:
+		resp.Answer = append(resp.Answer, &mdns.A{Hdr: header, A: net.ParseIP("192.0.2.1")})
+	case mdns.TypeAAAA:
+		resp.Answer = append(resp.Answer, &mdns.AAAA{Hdr: header, AAAA: net.ParseIP("2001:db8::1")})
+	case mdns.TypeTXT:
+		resp.Answer = append(resp.Answer, &mdns.TXT{Hdr: header, Txt: []string{"test"}})
+	}
+	w.WriteMsg(resp)
+}
+
+// series returns the metrics of the family name whose labels include match
+func series(t *testing.T, registry *prometheus.Registry, name string, match map[string]string) []*dto.Metric {
+	t.Helper()
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var metrics []*dto.Metric
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.Metric {
+			matches := true
+			for label, value := range match {
+				if v, ok := labelValue(metric, label); !ok || v != value {
+					matches = false
+				}
+			}
+			if matches {
+				metrics = append(metrics, metric)
+			}
+		}
+	}
+	return metrics
+}
+
+func TestStaleIPSeriesDeleted(t *testing.T) {
+	resolver, registry := newTestResolver(t, config.DefaultConfig())
+
+	var mu sync.Mutex
+	answer := []string{"192.0.2.1", "192.0.2.2"}
+	server := startTestServer(t, func(w mdns.ResponseWriter, req *mdns.Msg) {
+		mu.Lock()
+		defer mu.Unlock()
+		resp := new(mdns.Msg)
+		resp.SetReply(req)
+		for _, ip := range answer {
+			resp.Answer = append(resp.Answer, &mdns.A{
+				Hdr: mdns.RR_Header{Name: req.Question[0].Name, Rrtype: mdns.TypeA, Class: mdns.ClassINET, Ttl: 60},
+				A:   net.ParseIP(ip),
+			})
+		}
+		w.WriteMsg(resp)
+	})
+
+	tests := []struct {
+		name   string
+		answer []string
+	}{
+		{"initial answer", []string{"192.0.2.1", "192.0.2.2"}},
+		{"one address replaced", []string{"192.0.2.2", "192.0.2.3"}},
+		{"all addresses replaced", []string{"198.51.100.7"}},
+		{"address added back", []string{"192.0.2.1", "198.51.100.7"}},
+	}
+	for _, tt := range tests {
+		mu.Lock()
+		answer = tt.answer
+		mu.Unlock()
+
+		result := resolver.Lookup(dns.Query{FQDN: "stale-ips.example", RecordType: "A"}, server, time.Second)
+		if !result.Success {
+			t.Fatalf("%s: lookup failed: %v", tt.name, result.Error)
+		}
+		resolver.EndCycle()
+
+		var exported []string
+		for _, metric := range series(t, registry, "dns_resolved_ip_address", map[string]string{"fqdn": "stale-ips.example"}) {
+			ip, _ := labelValue(metric, "ip_address")
+			exported = append(exported, ip)
+		}
+		slices.Sort(exported)
+		if !slices.Equal(exported, tt.answer) {
+			t.Errorf("%s: exported addresses %v, want %v", tt.name, exported, tt.answer)
+		}
+	}
+}
+
+func TestProbeCombinations(t *testing.T) {
+	discardLog(t)
+	address := startTestServer(t, answerAll).Address
+	cfg := loadTestConfig(t, fmt.Sprintf(`
+monitoring:
+  dns_server_label: name
+dns_servers:
+  - name: combos-a
+    address: "%[1]s"
+    protocol: udp
+    tags: ["internal"]
+  - name: combos-b
+    address: "%[1]s"
+    protocol: udp
+targets:
+  - fqdn: both.combos.test
+    record_types: ["A", "AAAA"]
+  - fqdn: subnets.combos.test
+    client_subnet: ["192.0.2.0/24", "2001:db8::/48"]
+    dns_servers: ["combos-a"]
+  - fqdn: tagged.combos.test
+    record_types: ["A", "AAAA", "TXT"]
+    server_tags: ["internal"]
+  - fqdn: disabled.combos.test
+    enabled: false
+jobs:
+  - name: combos
+    dns_servers:
+      - name: combos-c
+        address: "%[1]s"
+        protocol: udp
+    targets:
+      - fqdn: job.combos.test
+`, address))
+	servers, err := buildServers(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// both: 2 types x 2 servers, subnets: 2 variants x 1 server,
+	// tagged: 3 types x 1 server, job: 1 type x the job's server
+	const want = 4 + 2 + 3 + 1
+	if got := probeCombinations(cfg, servers); got != want {
+		t.Fatalf("probeCombinations() = %d, want %d", got, want)
+	}
+
+	// A cycle performs as many lookups as there are combinations, and the
+	// per-server histogram counts each of them once like the per-fqdn one
+	resolver, registry := newTestResolver(t, cfg)
+	for _, group := range probeGroups(cfg) {
+		runCycle(resolver, cfg, servers, group)
+	}
+
+	lookups := 0
+	fqdnCounts := make(map[string]uint64)
+	for _, metric := range series(t, registry, "dns_query_total", nil) {
+		if fqdn, _ := labelValue(metric, "fqdn"); strings.HasSuffix(fqdn, ".combos.test") {
+			lookups += int(metric.GetCounter().GetValue())
+		}
+	}
+	for _, metric := range series(t, registry, "dns_response_duration_seconds", nil) {
+		fqdn, _ := labelValue(metric, "fqdn")
+		if !strings.HasSuffix(fqdn, ".combos.test") {
+			continue
+		}
+		server, _ := labelValue(metric, "dns_server")
+		recordType, _ := labelValue(metric, "record_type")
+		fqdnCounts[server+" "+recordType] += metric.GetHistogram().GetSampleCount()
+	}
+	if lookups != want {
+		t.Errorf("cycle performed %d lookups, want %d", lookups, want)
+	}
+
+	serverCounts := make(map[string]uint64)
+	for _, metric := range series(t, registry, "dns_server_response_duration_seconds", nil) {
+		server, _ := labelValue(metric, "dns_server")
+		recordType, _ := labelValue(metric, "record_type")
+		serverCounts[server+" "+recordType] += metric.GetHistogram().GetSampleCount()
+	}
+	// A, AAAA and TXT on combos-a, A and AAAA on combos-b, A on combos-c
+	if len(serverCounts) != 6 {
+		t.Errorf("server histogram has %d series, want 6: %v", len(serverCounts), serverCounts)
+	}
+	for key, count := range serverCounts {
+		if fqdnCounts[key] != count {
+			t.Errorf("%s: server histogram counted %d lookups, fqdn histograms %d", key, count, fqdnCounts[key])
+		}
+	}
+}
+
+// simulateCycles runs the scheduling of an interval for horizon with
+// cycles taking duration each, the way the monitoring loop does but on a
+// simulated clock. It returns the offsets cycles started at and the number
+// of skipped cycles.
+func simulateCycles(policy string, interval, duration, horizon time.Duration) ([]time.Duration, int) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var starts []time.Duration
+	var ends []time.Duration
+	skipped := 0
+	due := start
+	for now := time.Duration(0); now < horizon; {
+		ends = slices.DeleteFunc(ends, func(end time.Duration) bool { return end <= now })
+		busy := len(ends) > 0 && policy != config.OverrunPolicyOverlap
+		started, nextDue, skips := scheduleCycle(policy, interval, due, start.Add(now), busy)
+		due = nextDue
+		skipped += skips
+		if started {
+			starts = append(starts, now)
+			ends = append(ends, now+duration)
+		}
+
+		// The loop wakes up at the next interval, when the next cycle is
+		// due unless it queues behind a running one, or when a cycle is done
+		wake := now + interval
+		if (!busy || policy == config.OverrunPolicySkip) && due.Sub(start) < wake {
+			wake = due.Sub(start)
+		}
+		for _, end := range ends {
+			wake = min(wake, end)
+		}
+		now = max(now, wake)
+	}
+	return starts, skipped
+}
+
+func TestScheduleCycleSlowLookups(t *testing.T) {
+	const interval = 10 * time.Second
+	seconds := func(offsets ...int) []time.Duration {
+		var durations []time.Duration
+		for _, offset := range offsets {
+			durations = append(durations, time.Duration(offset)*time.Second)
+		}
+		return durations
+	}
+
+	tests := []struct {
+		name     string
+		policy   string
+		duration time.Duration
+		starts   []time.Duration
+		skipped  int
+	}{
+		{"fast cycles, skip", config.OverrunPolicySkip, 5 * time.Second, seconds(0, 10, 20, 30, 40, 50, 60, 70, 80, 90), 0},
+		{"fast cycles, queue", config.OverrunPolicyQueue, 5 * time.Second, seconds(0, 10, 20, 30, 40, 50, 60, 70, 80, 90), 0},
+		{"fast cycles, overlap", config.OverrunPolicyOverlap, 5 * time.Second, seconds(0, 10, 20, 30, 40, 50, 60, 70, 80, 90), 0},
+		// Ticks during an overrun are dropped, the next cycle starts on
+		// the following tick
+		{"slow cycles, skip", config.OverrunPolicySkip, 25 * time.Second, seconds(0, 30, 60, 90), 6},
+		// Missed ticks run back to back as soon as the running cycle is done
+		{"slow cycles, queue", config.OverrunPolicyQueue, 25 * time.Second, seconds(0, 25, 50, 75), 0},
+		// Cycles start on every tick regardless of the running ones
+		{"slow cycles, overlap", config.OverrunPolicyOverlap, 25 * time.Second, seconds(0, 10, 20, 30, 40, 50, 60, 70, 80, 90), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			starts, skipped := simulateCycles(tt.policy, interval, tt.duration, 100*time.Second)
+			if !slices.Equal(starts, tt.starts) {
+				t.Errorf("cycles started at %v, want %v", starts, tt.starts)
+			}
+			if skipped != tt.skipped {
+				t.Errorf("%d cycles skipped, want %d", skipped, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestScheduleCycleLateWakeUp(t *testing.T) {
+	// A loop waking up long after the cycle was due, e.g. after the host
+	// was suspended, starts one cycle and skips the ticks it missed
+	const interval = 10 * time.Second
+	due := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := due.Add(35 * time.Second)
+
+	start, next, skipped := scheduleCycle(config.OverrunPolicySkip, interval, due, now, false)
+	if !start || skipped != 3 || !next.Equal(due.Add(40*time.Second)) {
+		t.Errorf("skip: start %v, next due +%v, %d skipped, want a start, next due +40s and 3 skipped", start, next.Sub(due), skipped)
+	}
+
+	// With the queue policy the missed ticks follow back to back
+	start, next, skipped = scheduleCycle(config.OverrunPolicyQueue, interval, due, now, false)
+	if !start || skipped != 0 || !next.Equal(due.Add(10*time.Second)) {
+		t.Errorf("queue: start %v, next due +%v, %d skipped, want a start, next due +10s and none skipped", start, next.Sub(due), skipped)
+	}
+}
+
+func TestGroupsShareDoQConnections(t *testing.T) {
+	discardLog(t)
+	cfg := loadTestConfig(t, `
+monitoring:
+  interval: 30s
+dns_servers:
+  - name: groups-doq
+    address: 127.0.0.1
+targets:
+  - fqdn: slow.groups.test
+  - fqdn: fast.groups.test
+    interval: 10s
+`)
+	resolver, registry := newTestResolver(t, cfg)
+
+	// The lookup of the slow target is answered once the cycle of the
+	// other group, querying the same server, is done
+	slowQueried := make(chan struct{})
+	fastDone := make(chan struct{})
+	var once sync.Once
+	server := startDoQServer(t, func(req *mdns.Msg) *mdns.Msg {
+		if req.Question[0].Name == "slow.groups.test." {
+			once.Do(func() { close(slowQueried) })
+			<-fastDone
+		}
+		resp := new(mdns.Msg)
+		resp.SetReply(req)
+		resp.Answer = append(resp.Answer, &mdns.A{
+			Hdr: mdns.RR_Header{Name: req.Question[0].Name, Rrtype: mdns.TypeA, Class: mdns.ClassINET, Ttl: 60},
+			A:   net.ParseIP("192.0.2.1"),
+		})
+		return resp
+	})
+	server.Name = "groups-doq"
+	server.Timeout = 5 * time.Second
+	servers := []dns.Server{server}
+
+	groups := probeGroups(cfg)
+	if len(groups) != 2 {
+		t.Fatalf("%d probe groups, want 2", len(groups))
+	}
+	slowCycle := make(chan struct{})
+	go func() {
+		runCycle(resolver, cfg, servers, groups[0])
+		close(slowCycle)
+	}()
+	<-slowQueried
+	runCycle(resolver, cfg, servers, groups[1])
+	close(fastDone)
+	<-slowCycle
+
+	for _, fqdn := range []string{"slow.groups.test", "fast.groups.test"} {
+		metrics := series(t, registry, "dns_resolution_success", map[string]string{"fqdn": fqdn})
+		if len(metrics) != 1 || metrics[0].GetGauge().GetValue() != 1 {
+			t.Errorf("%s: dns_resolution_success %v, want 1", fqdn, metrics)
+		}
+	}
+	resolver.ResetConnections()
+}
+
+func TestPrintDefaultConfig(t *testing.T) {
+	// The test binary runs main with the flag in a process of its own, as
+	// main exits on errors
+	if os.Getenv("DNS_EXPORTER_TEST_MAIN") == "1" {
+		os.Args = []string{"dns-exporter", "-print-default-config"}
+		main()
+		os.Exit(0)
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestPrintDefaultConfig$")
+	cmd.Env = append(os.Environ(), "DNS_EXPORTER_TEST_MAIN=1")
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("-print-default-config: %v", err)
+	}
+
+	var want bytes.Buffer
+	if err := config.WriteDefault(&want); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output, want.Bytes()) {
+		t.Errorf("-print-default-config printed\n%s\nwant\n%s", output, want.Bytes())
+	}
+
+	// Loading the printed configuration results in the defaults
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, output, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadConfig(path, config.LoadOptions{})
+	if err != nil {
+		t.Fatalf("loading the default configuration: %v", err)
+	}
+	// Compared as YAML, as empty lists are read back as empty rather than
+	// nil slices
+	loaded, err := yaml.Marshal(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults, err := yaml.Marshal(config.DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded, defaults) {
+		t.Errorf("default configuration loaded as\n%s\nwant\n%s", loaded, defaults)
+	}
+}
+
+func TestJitterTargets(t *testing.T) {
+	var targets []config.Target
+	var names []string
+	for i := range 50 {
+		name := fmt.Sprintf("jitter%d.example.com", i)
+		targets = append(targets, config.Target{FQDN: name})
+		names = append(names, name)
+	}
+	fqdns := func(targets []config.Target) []string {
+		var names []string
+		for _, target := range targets {
+			names = append(names, target.FQDN)
+		}
+		return names
+	}
+
+	for _, jitter := range []time.Duration{time.Nanosecond, time.Millisecond, 5 * time.Second, 30 * time.Second} {
+		// Repeated, as the offsets are random
+		for range 20 {
+			jittered, offsets := jitterTargets(targets, jitter)
+			if len(offsets) != len(targets) {
+				t.Fatalf("jitter %v: %d offsets for %d targets", jitter, len(offsets), len(targets))
+			}
+			for _, offset := range offsets {
+				if offset < 0 || offset >= jitter {
+					t.Fatalf("jitter %v: offset %v outside [0, %v)", jitter, offset, jitter)
+				}
+			}
+			if !slices.IsSorted(offsets) {
+				t.Fatalf("jitter %v: offsets %v are not increasing", jitter, offsets)
+			}
+			// Every target is probed once, and the caller's slice is not
+			// reordered
+			if got := slices.Sorted(slices.Values(fqdns(jittered))); !slices.Equal(got, slices.Sorted(slices.Values(names))) {
+				t.Fatalf("jitter %v: targets %v, want a permutation of %v", jitter, got, names)
+			}
+			if !slices.Equal(fqdns(targets), names) {
+				t.Fatalf("jitter %v: the targets passed in were reordered", jitter)
+			}
+		}
+	}
+
+	// Without jitter the targets keep their order and are probed at once
+	jittered, offsets := jitterTargets(targets, 0)
+	if got := fqdns(jittered); !slices.Equal(got, names) {
+		t.Errorf("without jitter: targets %v, want %v", got, names)
+	}
+	if slices.ContainsFunc(offsets, func(offset time.Duration) bool { return offset != 0 }) {
+		t.Errorf("without jitter: offsets %v, want all 0", offsets)
+	}
+}